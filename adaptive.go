@@ -0,0 +1,84 @@
+package crawl
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	// densityBits is the length of the key prefixes discovered peers are
+	// counted under for WithAdaptiveAnchors.
+	densityBits = 4
+	// minDensityPeers is the number of discovered peers below which adaptive
+	// anchors are uniformly random.
+	minDensityPeers = 64
+)
+
+// density counts discovered peers by the prefix of their DHT key.
+type density struct {
+	mx     sync.Mutex
+	counts [1 << densityBits]int
+	total  int
+}
+
+func (d *density) add(p peer.ID) {
+	// the DHT maps peer IDs to the keyspace by their SHA-256 hash
+	h := sha256.Sum256([]byte(p))
+	b := keyPrefix(h[:], densityBits)
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.counts[b]++
+	d.total++
+}
+
+// sparseBucket picks a key prefix with probability inversely proportional to
+// the number of peers discovered under it, returning false while too few
+// peers have been discovered to tell.
+func (d *density) sparseBucket(int63n func(int64) int64) (uint32, bool) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.total < minDensityPeers {
+		return 0, false
+	}
+
+	var weights [len(d.counts)]int64
+	var sum int64
+	for i, n := range d.counts {
+		weights[i] = int64(d.total) * 1024 / int64(n+1)
+		sum += weights[i]
+	}
+
+	x := int63n(sum)
+	for i, w := range weights {
+		if x < w {
+			return uint32(i), true
+		}
+		x -= w
+	}
+	return uint32(len(weights) - 1), true
+}
+
+// adaptiveAnchors returns a strategy drawing anchors from base until one
+// falls under a sparsely crawled key prefix.
+func (c *Crawler) adaptiveAnchors(base AnchorStrategy) AnchorStrategy {
+	return func() (string, error) {
+		bucket, ok := c.density.sparseBucket(c.int63n)
+		if !ok {
+			return base()
+		}
+
+		for {
+			anchor, err := base()
+			if err != nil {
+				return "", err
+			}
+			h := sha256.Sum256([]byte(anchor))
+			if keyPrefix(h[:], densityBits) == bucket {
+				return anchor, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package crawl
+
+import (
+	"context"
+	"crypto/sha256"
+	mrand "math/rand"
+	"testing"
+)
+
+// sparseDensity returns a density in which every key prefix but 3 and 9 has
+// had 100 peers discovered under it.
+func sparseDensity() *density {
+	d := &density{}
+	for i := range d.counts {
+		if i != 3 && i != 9 {
+			d.counts[i] = 100
+			d.total += 100
+		}
+	}
+	return d
+}
+
+func TestSparseBucket(t *testing.T) {
+	const draws = 1000
+
+	d := sparseDensity()
+	r := mrand.New(mrand.NewSource(1))
+	sparse := 0
+	for i := 0; i < draws; i++ {
+		b, ok := d.sparseBucket(r.Int63n)
+		if !ok {
+			t.Fatal("expected enough peers to pick a bucket")
+		}
+		if b == 3 || b == 9 {
+			sparse++
+		}
+	}
+	// the two empty buckets weigh about 93% of the total
+	if sparse < draws*85/100 {
+		t.Errorf("expected the sparse buckets to be picked most of the time, got %d of %d", sparse, draws)
+	}
+}
+
+func TestSparseBucketTooFewPeers(t *testing.T) {
+	d := &density{}
+	for i := 0; i < minDensityPeers-1; i++ {
+		d.add(testPeer(i))
+	}
+	if _, ok := d.sparseBucket(mrand.New(mrand.NewSource(1)).Int63n); ok {
+		t.Errorf("expected no bucket with only %d peers discovered", d.total)
+	}
+}
+
+func TestAdaptiveAnchors(t *testing.T) {
+	const draws = 200
+
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil), WithAdaptiveAnchors(true),
+		WithRandSource(mrand.New(mrand.NewSource(1))))
+	defer c.Close()
+	rl := &lockedRand{r: mrand.New(mrand.NewSource(2))}
+	base := func() (string, error) { return rl.anchor(context.Background()) }
+
+	// without density data, the base anchors are used as they are
+	want, _ := (&lockedRand{r: mrand.New(mrand.NewSource(2))}).anchor(context.Background())
+	if got, err := c.adaptiveAnchors(base)(); err != nil || got != want {
+		t.Fatalf("expected the uniformly random anchor %s, got %s (%v)", want, got, err)
+	}
+
+	c.density = sparseDensity()
+	anchors := c.adaptiveAnchors(base)
+	sparse := 0
+	for i := 0; i < draws; i++ {
+		a, err := anchors()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256([]byte(a))
+		if b := keyPrefix(h[:], densityBits); b == 3 || b == 9 {
+			sparse++
+		}
+	}
+	if sparse < draws*85/100 {
+		t.Errorf("expected the anchors to concentrate in the sparse buckets, got %d of %d", sparse, draws)
+	}
+}
@@ -0,0 +1,33 @@
+package crawl
+
+import "strings"
+
+// ClientUnknown is the client of peers whose agent version is not recognized.
+const ClientUnknown = "unknown"
+
+// agentClients maps agent version prefixes to client labels. go-ipfs was
+// renamed kubo, so both map to the same label.
+var agentClients = []struct {
+	prefix string
+	client string
+}{
+	{"kubo/", "kubo"},
+	{"go-ipfs/", "kubo"},
+	{"js-ipfs/", "js-ipfs"},
+	{"lotus-", "lotus"},
+	{"lotus/", "lotus"},
+	{"storm", "storm"},
+}
+
+// ClassifyAgent returns a normalized label for the client implementation
+// reporting agentVersion, such as "kubo" for "go-ipfs/0.4.18/", or
+// ClientUnknown.
+func ClassifyAgent(agentVersion string) string {
+	av := strings.ToLower(strings.TrimSpace(agentVersion))
+	for _, ac := range agentClients {
+		if strings.HasPrefix(av, ac.prefix) {
+			return ac.client
+		}
+	}
+	return ClientUnknown
+}
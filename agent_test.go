@@ -0,0 +1,47 @@
+package crawl
+
+import "testing"
+
+func TestClassifyAgent(t *testing.T) {
+	for av, want := range map[string]string{
+		"go-ipfs/0.4.18/":                "kubo",
+		"go-ipfs/0.11.0/67220ed":         "kubo",
+		"kubo/0.18.1/675f8bd":            "kubo",
+		"kubo/0.22.0/desktop":            "kubo",
+		"js-ipfs/0.33.1":                 "js-ipfs",
+		"lotus-1.23.3+mainnet+git.7bb1f": "lotus",
+		"lotus/1.4.1+git.d17d91e":        "lotus",
+		"storm":                          "storm",
+		"  Go-IPFS/0.5.0/ ":              "kubo",
+		"go-libp2p":                      ClientUnknown,
+		"rust-libp2p/0.41.0":             ClientUnknown,
+		"":                               ClientUnknown,
+	} {
+		if got := ClassifyAgent(av); got != want {
+			t.Errorf("expected %q to be classified as %s, got %s", av, want, got)
+		}
+	}
+}
+
+func TestRecordClient(t *testing.T) {
+	h := newMockHost()
+	h.ps.Put(testPeer(0), "AgentVersion", "kubo/0.18.1/675f8bd")
+	c := newTestCrawler(t, h, newMockDHT(starGraph(1), 0))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	want := map[string]string{
+		testPeer(0).Pretty(): "kubo",
+		// identify hasn't told us anything about peer 1
+		testPeer(1).Pretty(): ClientUnknown,
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(recs))
+	}
+	for _, rec := range recs {
+		if c := want[rec.PeerInfo.ID.Pretty()]; rec.Client != c {
+			t.Errorf("expected %s to be tagged %s, got %s", rec.PeerInfo.ID.Pretty(), c, rec.Client)
+		}
+	}
+}
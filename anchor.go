@@ -0,0 +1,101 @@
+package crawl
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	randRetries = 3
+	randBackoff = 100 * time.Millisecond
+)
+
+// randRead reads the random bytes anchors are generated from.
+var randRead = crand.Read
+
+// AnchorStrategy generates the keys whose closest peers seed each crawl
+// round.
+type AnchorStrategy func() (string, error)
+
+// RandomAnchors generates uniformly random anchors. It is the default
+// strategy. Failing reads of the system's entropy source are retried a few
+// times before giving up.
+func RandomAnchors() (string, error) {
+	return randomAnchor(context.Background(), randRead)
+}
+
+// randomAnchor generates a random anchor from read, giving up on retries once
+// ctx is done.
+func randomAnchor(ctx context.Context, read func([]byte) (int, error)) (string, error) {
+	anchor := make([]byte, 32)
+
+	var err error
+	for i := 0; i < randRetries; i++ {
+		if i > 0 {
+			t := time.NewTimer(time.Duration(i) * randBackoff)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return "", ctx.Err()
+			}
+		}
+		if _, err = read(anchor); err == nil {
+			return base64.RawStdEncoding.EncodeToString(anchor), nil
+		}
+	}
+
+	return "", err
+}
+
+// XORWalkStrategy returns a strategy that systematically covers the keyspace:
+// it walks the 2^bits key prefixes in order, generating for each an anchor
+// whose DHT key falls under it. Finding an anchor takes 2^bits attempts on
+// average, so bits should be kept small. Candidates are drawn from the
+// system's entropy source; WithXORWalk draws them as random anchors are.
+func XORWalkStrategy(bits int) AnchorStrategy {
+	return xorWalk(bits, RandomAnchors)
+}
+
+// xorWalk is XORWalkStrategy drawing candidate anchors from random.
+func xorWalk(bits int, random AnchorStrategy) AnchorStrategy {
+	if bits < 1 || bits > 16 {
+		return func() (string, error) {
+			return "", fmt.Errorf("invalid XOR walk prefix length: %d", bits)
+		}
+	}
+
+	var mx sync.Mutex
+	var next uint32
+
+	return func() (string, error) {
+		mx.Lock()
+		prefix := next
+		next = (next + 1) % (1 << uint(bits))
+		mx.Unlock()
+
+		for {
+			anchor, err := random()
+			if err != nil {
+				return "", err
+			}
+
+			// the DHT maps keys to the keyspace by their SHA-256 hash
+			h := sha256.Sum256([]byte(anchor))
+			if keyPrefix(h[:], bits) == prefix {
+				return anchor, nil
+			}
+		}
+	}
+}
+
+// keyPrefix returns the first bits bits of key.
+func keyPrefix(key []byte, bits int) uint32 {
+	v := uint32(key[0])<<24 | uint32(key[1])<<16 | uint32(key[2])<<8 | uint32(key[3])
+	return v >> uint(32-bits)
+}
@@ -0,0 +1,131 @@
+package crawl
+
+import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyRead returns a reader that fails the first n reads.
+func flakyRead(n int) func([]byte) (int, error) {
+	var mx sync.Mutex
+	return func(b []byte) (int, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		if n > 0 {
+			n--
+			return 0, errors.New("entropy unavailable")
+		}
+		return crand.Read(b)
+	}
+}
+
+func TestRandomAnchorRetries(t *testing.T) {
+	if _, err := randomAnchor(context.Background(), flakyRead(randRetries-1)); err != nil {
+		t.Errorf("expected reads to be retried, got %s", err)
+	}
+	if _, err := randomAnchor(context.Background(), flakyRead(randRetries)); err == nil {
+		t.Error("expected an error once the retries ran out")
+	}
+}
+
+func TestRandomAnchorCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	if _, err := randomAnchor(ctx, flakyRead(randRetries)); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+	if dt := time.Since(start); dt >= randBackoff {
+		t.Errorf("expected the retry wait to be cut short, took %s", dt)
+	}
+}
+
+func TestCrawlSurvivesReadErrors(t *testing.T) {
+	defer func(read func([]byte) (int, error)) { randRead = read }(randRead)
+	randRead = flakyRead(1)
+
+	d := newMockDHT(nil, 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+	waitFor(t, "an anchor", func() bool { return len(d.queried()) > 0 })
+	c.Close()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// a persistent failure stops the crawl with an error
+	randRead = flakyRead(randRetries)
+	c = newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+	if err := c.Crawl(); err == nil {
+		t.Error("expected Crawl to fail without anchors")
+	}
+}
+
+func TestXORWalkStrategy(t *testing.T) {
+	const bits = 2
+	s := XORWalkStrategy(bits)
+
+	// anchors walk the prefixes in order, wrapping after 2^bits
+	for i := 0; i < 10; i++ {
+		anchor, err := s()
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256([]byte(anchor))
+		if prefix := keyPrefix(h[:], bits); prefix != uint32(i%(1<<bits)) {
+			t.Errorf("anchor %d: expected prefix %d, got %d", i, i%(1<<bits), prefix)
+		}
+	}
+
+	if _, err := XORWalkStrategy(0)(); err == nil {
+		t.Error("expected an invalid prefix length to fail")
+	}
+}
+
+func TestXORWalkRandSource(t *testing.T) {
+	// the walk must not touch the system's entropy source
+	defer func(read func([]byte) (int, error)) { randRead = read }(randRead)
+	randRead = func([]byte) (int, error) { return 0, errors.New("entropy used") }
+
+	crawl := func() []string {
+		d := newMockDHT(nil, 0)
+		c := newTestCrawler(t, newMockHost(), d, WithXORWalk(2), WithRandSource(mrand.New(mrand.NewSource(42))))
+		defer c.Close()
+		for round := 0; round < 5; round++ {
+			if err := c.CrawlRound(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return d.queried()
+	}
+
+	anchors := crawl()
+	if len(anchors) != 5 {
+		t.Fatalf("expected 5 anchors, got %v", anchors)
+	}
+	for i, anchor := range anchors {
+		h := sha256.Sum256([]byte(anchor))
+		if prefix := keyPrefix(h[:], 2); prefix != uint32(i%4) {
+			t.Errorf("anchor %d: expected prefix %d, got %d", i, i%4, prefix)
+		}
+	}
+	if again := crawl(); fmt.Sprint(again) != fmt.Sprint(anchors) {
+		t.Errorf("expected the same anchors from the same seed, got %v and %v", anchors, again)
+	}
+
+	if _, err := NewCrawler(context.Background(), newMockHost(), newMockDHT(nil), WithXORWalk(2), WithAdaptiveAnchors(true)); err == nil {
+		t.Error("expected an XOR walk not to combine with adaptive anchors")
+	}
+}
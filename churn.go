@@ -0,0 +1,117 @@
+package crawl
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// ChurnEvent reports a crawled peer that disconnected shortly after the crawl
+// connected to it.
+type ChurnEvent struct {
+	ID             peer.ID
+	ConnectedAt    time.Time
+	DisconnectedAt time.Time
+}
+
+// churnTracker watches the host's network for disconnections of peers the
+// crawl connected to.
+type churnTracker struct {
+	c      *Crawler
+	window time.Duration
+
+	mx        sync.Mutex
+	connected map[peer.ID]time.Time
+
+	notifiee *inet.NotifyBundle
+}
+
+func newChurnTracker(c *Crawler, window time.Duration) *churnTracker {
+	t := &churnTracker{c: c, window: window, connected: make(map[peer.ID]time.Time)}
+	t.notifiee = &inet.NotifyBundle{DisconnectedF: t.disconnected}
+	return t
+}
+
+// track starts watching p, which the crawl has just connected to.
+func (t *churnTracker) track(p peer.ID) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.connected[p] = time.Now()
+}
+
+// forget stops watching p, e.g. because the crawl is closing the connection
+// itself.
+func (t *churnTracker) forget(p peer.ID) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	delete(t.connected, p)
+}
+
+func (t *churnTracker) disconnected(n inet.Network, conn inet.Conn) {
+	p := conn.RemotePeer()
+	if n.Connectedness(p) == inet.Connected {
+		return
+	}
+
+	t.mx.Lock()
+	at, ok := t.connected[p]
+	delete(t.connected, p)
+	t.mx.Unlock()
+
+	now := time.Now()
+	if !ok || now.Sub(at) > t.window {
+		return
+	}
+
+	t.c.log.Debugf("Peer %s disconnected %s after connecting", p.Pretty(), now.Sub(at))
+	t.c.emitChurn(ChurnEvent{ID: p, ConnectedAt: at, DisconnectedAt: now})
+}
+
+// ChurnSummary compares the peers visited by two crawls.
+type ChurnSummary struct {
+	// Joined are the peers visited only by the current crawl, Left those
+	// visited only by the previous one, and Stable those visited by both.
+	Joined []peer.ID
+	Left   []peer.ID
+	Stable []peer.ID
+}
+
+// CompareCrawls summarizes the churn between the peers visited by a previous
+// crawl and the current one.
+func CompareCrawls(prev, cur []peer.ID) ChurnSummary {
+	before := make(map[peer.ID]struct{}, len(prev))
+	for _, p := range prev {
+		before[p] = struct{}{}
+	}
+
+	var sum ChurnSummary
+	now := make(map[peer.ID]struct{}, len(cur))
+	for _, p := range cur {
+		now[p] = struct{}{}
+		if _, ok := before[p]; ok {
+			sum.Stable = append(sum.Stable, p)
+		} else {
+			sum.Joined = append(sum.Joined, p)
+		}
+	}
+	for p := range before {
+		if _, ok := now[p]; !ok {
+			sum.Left = append(sum.Left, p)
+		}
+	}
+
+	for _, ps := range [][]peer.ID{sum.Joined, sum.Left, sum.Stable} {
+		sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	}
+	return sum
+}
+
+// ChurnSummary compares the peers visited so far with those of the previous
+// crawl given with WithPreviousCrawl. Call it once Crawl returns for the
+// churn between full crawls.
+func (c *Crawler) ChurnSummary() ChurnSummary {
+	return CompareCrawls(c.previous, c.peers.list())
+}
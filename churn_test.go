@@ -0,0 +1,107 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestChurnTracking(t *testing.T) {
+	h := newMockHost()
+	c := newTestCrawler(t, h, newMockDHT(nil, 0), WithChurnTracking(time.Minute))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rec := <-c.Records:
+		if rec.Connectedness != inet.Connected {
+			t.Errorf("expected the record to show the connection, got %s", connectednessString(rec.Connectedness))
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("peer not recorded")
+	}
+
+	h.net.ClosePeer(testPeer(0))
+
+	select {
+	case ev := <-c.Churn:
+		if ev.ID != testPeer(0) || ev.DisconnectedAt.Before(ev.ConnectedAt) {
+			t.Errorf("unexpected churn event %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("disconnection not reported")
+	}
+}
+
+// churnNums maps the peers in each part of sum to their numbers.
+func churnNums(sum ChurnSummary) string {
+	nums := func(ps []peer.ID) []int {
+		return recorded(peerRecords(ps), 9)
+	}
+	return fmt.Sprintf("joined %v left %v stable %v", nums(sum.Joined), nums(sum.Left), nums(sum.Stable))
+}
+
+func peerRecords(ps []peer.ID) []PeerRecord {
+	var recs []PeerRecord
+	for _, p := range ps {
+		recs = append(recs, PeerRecord{PeerInfo: pstore.PeerInfo{ID: p}})
+	}
+	return recs
+}
+
+func TestCompareCrawls(t *testing.T) {
+	ids := func(ns ...int) []peer.ID {
+		var ps []peer.ID
+		for _, n := range ns {
+			ps = append(ps, testPeer(n))
+		}
+		return ps
+	}
+
+	for _, tc := range []struct {
+		prev, cur []peer.ID
+		want      string
+	}{
+		{nil, ids(0, 1), "joined [0 1] left [] stable []"},
+		{ids(0, 1), nil, "joined [] left [0 1] stable []"},
+		{ids(0, 1, 2), ids(3, 1, 0), "joined [3] left [2] stable [0 1]"},
+	} {
+		if got := churnNums(CompareCrawls(tc.prev, tc.cur)); got != tc.want {
+			t.Errorf("expected %s, got %s", tc.want, got)
+		}
+	}
+}
+
+func TestChurnSummary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfs-crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	c := newTestCrawler(t, newMockHost(), newMockDHT(map[int][]int{0: {1, 2}}, 0), WithStateFile(path))
+	crawlOnce(t, c)
+	c.Close()
+
+	// peer 2 left the network and peer 3 joined it
+	c = newTestCrawler(t, newMockHost(), newMockDHT(map[int][]int{0: {1, 3}}, 0), WithPreviousCrawl(path))
+	defer c.Close()
+	if recs := crawlOnce(t, c); len(recs) != 3 {
+		t.Fatalf("expected the previously seen peers to be crawled again, got %d records", len(recs))
+	}
+
+	if got := churnNums(c.ChurnSummary()); got != "joined [3] left [2] stable [0 1]" {
+		t.Errorf("unexpected churn between the crawls: %s", got)
+	}
+}
@@ -0,0 +1,49 @@
+package crawl
+
+import (
+	"context"
+	"time"
+)
+
+// CollectFor crawls for d, or until ctx is done or the crawl stops on its
+// own, and returns the records received on Records. The crawler is closed
+// when CollectFor returns.
+func (c *Crawler) CollectFor(ctx context.Context, d time.Duration) []PeerRecord {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := c.Crawl(); err != nil && err != context.Canceled {
+			c.log.Warnf("Crawl stopped: %s", err.Error())
+		}
+	}()
+
+	var recs []PeerRecord
+loop:
+	for {
+		select {
+		case rec, ok := <-c.Records:
+			if !ok {
+				break loop
+			}
+			recs = append(recs, rec)
+		case <-done:
+			// Records stays open if Crawl failed
+			break loop
+		case <-ctx.Done():
+			break loop
+		}
+	}
+
+	c.Close()
+	<-done
+
+	// pick up anything the workers emitted before exiting
+	for rec := range c.Records {
+		recs = append(recs, rec)
+	}
+
+	return recs
+}
@@ -0,0 +1,37 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCollectFor(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(2), 0), WithAnchorInterval(time.Hour))
+
+	start := time.Now()
+	recs := c.CollectFor(context.Background(), 200*time.Millisecond)
+
+	if dt := time.Since(start); dt > time.Second {
+		t.Errorf("expected CollectFor to return after 200ms, took %s", dt)
+	}
+	if got := recorded(recs, 2); len(got) != 3 {
+		t.Errorf("expected records for peers 0 to 2, got %v", got)
+	}
+}
+
+func TestCollectForCrawlError(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0),
+		WithAnchorStrategy(func() (string, error) { return "", errors.New("no anchors") }))
+
+	start := time.Now()
+	recs := c.CollectFor(context.Background(), time.Hour)
+
+	if dt := time.Since(start); dt > time.Second {
+		t.Errorf("expected CollectFor to return once Crawl failed, took %s", dt)
+	}
+	if len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+}
@@ -0,0 +1,400 @@
+package crawl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	mrand "math/rand"
+	"strings"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// fileConfig is the JSON config file read by OptionsFromFile. Fields left out
+// of the file keep their defaults.
+type fileConfig struct {
+	Workers               *int         `json:"workers"`
+	Expanders             *int         `json:"expanders"`
+	RecordsBuffer         *int         `json:"records_buffer"`
+	RecentBuffer          *int         `json:"recent_buffer"`
+	AutoScale             *scaleFile   `json:"auto_scale"`
+	DialTimeout           *duration    `json:"dial_timeout"`
+	QueryTimeout          *duration    `json:"query_timeout"`
+	QueryRetries          *retryFile   `json:"query_retries"`
+	FindPeerTimeout       *duration    `json:"find_peer_timeout"`
+	ConnectedPeersTimeout *duration    `json:"connected_peers_timeout"`
+	AnchorInterval        *duration    `json:"anchor_interval"`
+	AnchorsPerRound       *int         `json:"anchors_per_round"`
+	AdaptiveAnchors       *bool        `json:"adaptive_anchors"`
+	XORWalk               *int         `json:"xor_walk"`
+	RandSeed              *int64       `json:"rand_seed"`
+	ConnectJitter         *duration    `json:"connect_jitter"`
+	IdentifyWait          *duration    `json:"identify_wait"`
+	IdentifyTimeout       *duration    `json:"identify_timeout"`
+	StatsInterval         *duration    `json:"stats_interval"`
+	ConnectRate           *rateFile    `json:"connect_rate"`
+	QueryRate             *rateFile    `json:"query_rate"`
+	MaxQueries            *int         `json:"max_concurrent_queries"`
+	MaxInflightDials      *int         `json:"max_inflight_dials"`
+	MaxDialRetries        *int         `json:"max_dial_retries"`
+	Backoff               *backoffFile `json:"backoff"`
+	BackoffHandling       *bool        `json:"backoff_handling"`
+	MaxPeers              *int         `json:"max_peers"`
+	MaxDepth              *int         `json:"max_depth"`
+	MaxNeighborsPerPeer   *int         `json:"max_neighbors_per_peer"`
+	SaturationRounds      *int         `json:"saturation_rounds"`
+	RefreshInterval       *duration    `json:"refresh_interval"`
+	VisitedBloom          *bloomFile   `json:"visited_bloom"`
+	Ping                  *bool        `json:"ping"`
+	Disconnect            *bool        `json:"disconnect_after_discovery"`
+	ConnManager           *connMgrFile `json:"conn_manager"`
+	ChurnWindow           *duration    `json:"churn_window"`
+	DryRun                *bool        `json:"dry_run"`
+	Bootstrap             *bool        `json:"bootstrap"`
+	DedupByPubKey         *bool        `json:"dedup_by_pub_key"`
+	PrivateAddrs          *bool        `json:"private_addrs"`
+	MinAddresses          *int         `json:"min_addresses"`
+	AddressFamily         *string      `json:"address_family"`
+	Transports            []string     `json:"transports"`
+	Blacklist             []string     `json:"blacklist"`
+	Whitelist             []string     `json:"whitelist"`
+	SeedsFile             *string      `json:"seeds_file"`
+	StateFile             *string      `json:"state_file"`
+	PreviousCrawl         *string      `json:"previous_crawl"`
+	SQLiteSink            *string      `json:"sqlite_sink"`
+	StreamOrigins         []string     `json:"stream_origins"`
+}
+
+// duration is a time.Duration written as a string such as "30s".
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("invalid duration %s: must be a string such as \"30s\"", b)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// rateFile is a rate limit in events per second, with a burst of one unless
+// given.
+type rateFile struct {
+	PerSecond float64 `json:"per_second"`
+	Burst     int     `json:"burst"`
+}
+
+func (r *rateFile) limit() (float64, int) {
+	burst := r.Burst
+	if burst == 0 {
+		burst = 1
+	}
+	return r.PerSecond, burst
+}
+
+// retryFile is the query retry policy of WithQueryRetries.
+type retryFile struct {
+	Count int      `json:"count"`
+	Base  duration `json:"base"`
+}
+
+// backoffFile is the dial backoff delay of WithBackoff.
+type backoffFile struct {
+	Base duration `json:"base"`
+	Max  duration `json:"max"`
+}
+
+// scaleFile is the worker range of WithAutoScale.
+type scaleFile struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// connMgrFile is the connection manager of WithConnManager.
+type connMgrFile struct {
+	Low   int      `json:"low"`
+	High  int      `json:"high"`
+	Grace duration `json:"grace"`
+}
+
+// bloomFile is the bloom filter visited set of WithVisitedBloom.
+type bloomFile struct {
+	Peers             uint    `json:"peers"`
+	FalsePositiveRate float64 `json:"false_positive_rate"`
+}
+
+// OptionsFromFile reads crawler options from the JSON config file at path.
+// Durations are strings such as "30s", rates are objects with per_second and
+// burst fields, and transports are multiaddr protocol names such as "tcp".
+// Unknown fields and invalid values are reported as errors naming the field.
+// Options taking Go values, such as WithDHT, WithLogger or WithPeerFilter,
+// can only be given in code. A sqlite_sink path is opened as by
+// WithSQLitePath.
+func OptionsFromFile(path string) ([]Option, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fc fileConfig
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&fc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+
+	opts, err := fc.options()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return opts, nil
+}
+
+// options converts the file's fields into options, applying each to a
+// scratch config so that out-of-range values are reported against the field
+// they came from.
+func (fc *fileConfig) options() ([]Option, error) {
+	var opts []Option
+	cfg := defaults()
+	add := func(field string, o Option) error {
+		if err := o(&cfg); err != nil {
+			return fmt.Errorf("%s: %s", field, err)
+		}
+		opts = append(opts, o)
+		return nil
+	}
+
+	ints := []struct {
+		field string
+		v     *int
+		o     func(int) Option
+	}{
+		{"workers", fc.Workers, WithWorkers},
+		{"expanders", fc.Expanders, WithExpanders},
+		{"records_buffer", fc.RecordsBuffer, WithRecordsBuffer},
+		{"recent_buffer", fc.RecentBuffer, WithRecentBuffer},
+		{"anchors_per_round", fc.AnchorsPerRound, WithAnchorsPerRound},
+		{"xor_walk", fc.XORWalk, WithXORWalk},
+		{"max_concurrent_queries", fc.MaxQueries, WithMaxConcurrentQueries},
+		{"max_inflight_dials", fc.MaxInflightDials, WithMaxInflightDials},
+		{"max_dial_retries", fc.MaxDialRetries, WithMaxDialRetries},
+		{"max_peers", fc.MaxPeers, WithMaxPeers},
+		{"max_depth", fc.MaxDepth, WithMaxDepth},
+		{"max_neighbors_per_peer", fc.MaxNeighborsPerPeer, WithMaxNeighborsPerPeer},
+		{"saturation_rounds", fc.SaturationRounds, WithSaturationRounds},
+		{"min_addresses", fc.MinAddresses, WithMinAddresses},
+	}
+	for _, f := range ints {
+		if f.v == nil {
+			continue
+		}
+		if err := add(f.field, f.o(*f.v)); err != nil {
+			return nil, err
+		}
+	}
+
+	durations := []struct {
+		field string
+		v     *duration
+		o     func(time.Duration) Option
+	}{
+		{"dial_timeout", fc.DialTimeout, WithDialTimeout},
+		{"query_timeout", fc.QueryTimeout, WithQueryTimeout},
+		{"find_peer_timeout", fc.FindPeerTimeout, WithFindPeerTimeout},
+		{"connected_peers_timeout", fc.ConnectedPeersTimeout, WithConnectedPeersTimeout},
+		{"anchor_interval", fc.AnchorInterval, WithAnchorInterval},
+		{"connect_jitter", fc.ConnectJitter, WithConnectJitter},
+		{"identify_wait", fc.IdentifyWait, WithIdentifyWait},
+		{"identify_timeout", fc.IdentifyTimeout, WithIdentifyTimeout},
+		{"stats_interval", fc.StatsInterval, WithStatsInterval},
+		{"refresh_interval", fc.RefreshInterval, WithRefreshInterval},
+		{"churn_window", fc.ChurnWindow, WithChurnTracking},
+	}
+	for _, f := range durations {
+		if f.v == nil {
+			continue
+		}
+		if err := add(f.field, f.o(time.Duration(*f.v))); err != nil {
+			return nil, err
+		}
+	}
+
+	bools := []struct {
+		field string
+		v     *bool
+		o     func(bool) Option
+	}{
+		{"ping", fc.Ping, WithPing},
+		{"disconnect_after_discovery", fc.Disconnect, WithDisconnectAfterDiscovery},
+		{"dry_run", fc.DryRun, WithDryRun},
+		{"bootstrap", fc.Bootstrap, WithBootstrap},
+		{"dedup_by_pub_key", fc.DedupByPubKey, WithDedupByPubKey},
+		{"private_addrs", fc.PrivateAddrs, WithPrivateAddrs},
+		{"adaptive_anchors", fc.AdaptiveAnchors, WithAdaptiveAnchors},
+		{"backoff_handling", fc.BackoffHandling, WithBackoffHandling},
+	}
+	for _, f := range bools {
+		if f.v == nil {
+			continue
+		}
+		if err := add(f.field, f.o(*f.v)); err != nil {
+			return nil, err
+		}
+	}
+
+	strs := []struct {
+		field string
+		v     *string
+		o     func(string) Option
+	}{
+		{"state_file", fc.StateFile, WithStateFile},
+		{"previous_crawl", fc.PreviousCrawl, WithPreviousCrawl},
+		{"sqlite_sink", fc.SQLiteSink, WithSQLitePath},
+	}
+	for _, f := range strs {
+		if f.v == nil {
+			continue
+		}
+		if err := add(f.field, f.o(*f.v)); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc.ConnectRate != nil {
+		if err := add("connect_rate", WithConnectRate(fc.ConnectRate.limit())); err != nil {
+			return nil, err
+		}
+	}
+	if fc.QueryRate != nil {
+		if err := add("query_rate", WithQueryRate(fc.QueryRate.limit())); err != nil {
+			return nil, err
+		}
+	}
+
+	if r := fc.QueryRetries; r != nil {
+		if err := add("query_retries", WithQueryRetries(r.Count, time.Duration(r.Base))); err != nil {
+			return nil, err
+		}
+	}
+	if b := fc.Backoff; b != nil {
+		if err := add("backoff", WithBackoff(time.Duration(b.Base), time.Duration(b.Max))); err != nil {
+			return nil, err
+		}
+	}
+	if a := fc.AutoScale; a != nil {
+		if err := add("auto_scale", WithAutoScale(a.Min, a.Max)); err != nil {
+			return nil, err
+		}
+	}
+	if m := fc.ConnManager; m != nil {
+		if err := add("conn_manager", WithConnManager(m.Low, m.High, time.Duration(m.Grace))); err != nil {
+			return nil, err
+		}
+	}
+	if b := fc.VisitedBloom; b != nil {
+		if err := add("visited_bloom", WithVisitedBloom(b.Peers, b.FalsePositiveRate)); err != nil {
+			return nil, err
+		}
+	}
+	if fc.RandSeed != nil {
+		if err := add("rand_seed", WithRandSource(mrand.New(mrand.NewSource(*fc.RandSeed)))); err != nil {
+			return nil, err
+		}
+	}
+	if fc.StreamOrigins != nil {
+		if err := add("stream_origins", WithStreamOrigins(fc.StreamOrigins...)); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc.AddressFamily != nil {
+		family, err := parseAddressFamily(*fc.AddressFamily)
+		if err != nil {
+			return nil, fmt.Errorf("address_family: %s", err)
+		}
+		if err := add("address_family", WithAddressFamily(family)); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc.Transports != nil {
+		protocols := make([]int, 0, len(fc.Transports))
+		for _, name := range fc.Transports {
+			p := ma.ProtocolWithName(name)
+			if p.Code == 0 {
+				return nil, fmt.Errorf("transports: unknown protocol %q", name)
+			}
+			protocols = append(protocols, p.Code)
+		}
+		if err := add("transports", WithTransportFilter(protocols...)); err != nil {
+			return nil, err
+		}
+	}
+
+	lists := []struct {
+		field string
+		v     []string
+		o     func([]peer.ID) Option
+	}{
+		{"blacklist", fc.Blacklist, WithBlacklist},
+		{"whitelist", fc.Whitelist, WithWhitelist},
+	}
+	for _, f := range lists {
+		if f.v == nil {
+			continue
+		}
+		ids, err := decodePeers(f.v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.field, err)
+		}
+		if err := add(f.field, f.o(ids)); err != nil {
+			return nil, err
+		}
+	}
+
+	if fc.SeedsFile != nil {
+		seeds, err := LoadSeedsFromFile(*fc.SeedsFile)
+		if err != nil {
+			return nil, fmt.Errorf("seeds_file: %s", err)
+		}
+		if err := add("seeds_file", WithSeedPeers(seeds)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
+
+func parseAddressFamily(s string) (AddressFamily, error) {
+	switch strings.ToLower(s) {
+	case "", "any":
+		return AddressFamilyAny, nil
+	case "ipv4":
+		return AddressFamilyIPv4, nil
+	case "ipv6":
+		return AddressFamilyIPv6, nil
+	}
+	return 0, fmt.Errorf("unknown address family %q: must be any, ipv4 or ipv6", s)
+}
+
+func decodePeers(ss []string) ([]peer.ID, error) {
+	ids := make([]peer.ID, 0, len(ss))
+	for _, s := range ss {
+		p, err := peer.IDB58Decode(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer ID %q: %s", s, err)
+		}
+		ids = append(ids, p)
+	}
+	return ids, nil
+}
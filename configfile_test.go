@@ -0,0 +1,115 @@
+package crawl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// applied returns the config produced by applying opts to the defaults.
+func applied(t *testing.T, opts []Option) config {
+	t.Helper()
+	cfg := defaults()
+	for _, o := range opts {
+		if err := o(&cfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg
+}
+
+func TestOptionsFromFile(t *testing.T) {
+	opts, err := OptionsFromFile(filepath.Join("testdata", "crawl.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := applied(t, opts)
+
+	for _, c := range []struct {
+		field     string
+		got, want interface{}
+	}{
+		{"workers", cfg.workers, 2},
+		{"max workers", cfg.maxWorkers, 8},
+		{"dial timeout", cfg.dialTimeout, 10 * time.Second},
+		{"query timeout", cfg.queryTimeout, 30 * time.Second},
+		{"FindPeer timeout", cfg.findPeerTimeout, 20 * time.Second},
+		{"FindPeersConnectedToPeer timeout", cfg.connectedPeersTimeout, time.Duration(0)},
+		{"query retries", cfg.queryRetries, 3},
+		{"query retry base", cfg.queryRetryBase, 500 * time.Millisecond},
+		{"anchor interval", cfg.anchorInterval, time.Minute},
+		{"anchors per round", cfg.anchorsPerRound, 2},
+		{"connect rate", cfg.connectRate, 50.0},
+		{"connect burst", cfg.connectBurst, 5},
+		{"query rate", cfg.queryRate, 10.0},
+		{"query burst", cfg.queryBurst, 1},
+		{"max inflight dials", cfg.maxDials, 6},
+		{"backoff base", cfg.backoffBase, 2 * time.Second},
+		{"backoff max", cfg.backoffMax, time.Minute},
+		{"backoff handling", cfg.backoffHandling, false},
+		{"max peers", cfg.maxPeers, 1000},
+		{"max depth", cfg.maxDepth, 3},
+		{"ping", cfg.ping, true},
+		{"private addrs", cfg.privateAddrs, true},
+		{"address family", cfg.family, AddressFamilyIPv6},
+		{"seeds", len(cfg.seeds), 2},
+		{"rand source", cfg.rand != nil, true},
+		// fields left out of the file keep their defaults
+		{"records buffer", cfg.recordsBuffer, 256},
+		{"dial retries", cfg.dialRetries, 6},
+	} {
+		if c.got != c.want {
+			t.Errorf("expected %s to be %v, got %v", c.field, c.want, c.got)
+		}
+	}
+
+	bl, err := peer.IDB58Decode("QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cfg.blacklist[bl]; !ok || len(cfg.blacklist) != 1 {
+		t.Errorf("expected the blacklisted peer, got %v", cfg.blacklist)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("expected the config to be valid, got %s", err)
+	}
+}
+
+func TestOptionsFromFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfs-crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for config, want := range map[string]string{
+		`{"wokers": 4}`:                          `unknown field "wokers"`,
+		`{"workers": 0}`:                         "workers: invalid number of workers: 0",
+		`{"dial_timeout": 10}`:                   "invalid duration 10",
+		`{"dial_timeout": "10 seconds"}`:         "time: ",
+		`{"query_timeout": "-1s"}`:               "query_timeout: invalid query timeout: -1s",
+		`{"address_family": "ipx"}`:              `address_family: unknown address family "ipx"`,
+		`{"transports": ["tcp", "pigeon"]}`:      `transports: unknown protocol "pigeon"`,
+		`{"whitelist": ["not-a-peer"]}`:          `whitelist: invalid peer ID "not-a-peer"`,
+		`{"seeds_file": "testdata/missing.txt"}`: "seeds_file: ",
+		`{"visited_bloom": {"peers": 100, "false_positive_rate": 0.01}, "state_file": "state.json"}`: "a state file cannot be used with a bloom filter visited set",
+	} {
+		path := filepath.Join(dir, "crawl.json")
+		if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := OptionsFromFile(path)
+		if err == nil || !strings.HasPrefix(err.Error(), path+": ") || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %s to fail with %q, got %v", config, want, err)
+		}
+	}
+
+	if _, err := OptionsFromFile(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -2,145 +2,746 @@ package crawl
 
 import (
 	"context"
-	crand "crypto/rand"
-	"encoding/base64"
+	"errors"
 	"fmt"
-	"log"
-	mrand "math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	connmgr "github.com/libp2p/go-libp2p-connmgr"
 	host "github.com/libp2p/go-libp2p-host"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
+	inet "github.com/libp2p/go-libp2p-net"
 	peer "github.com/libp2p/go-libp2p-peer"
 	pstore "github.com/libp2p/go-libp2p-peerstore"
 	swarm "github.com/libp2p/go-libp2p-swarm"
 )
 
-const WORKERS = 16
+const roundsBuffer = 16
 
-type Crawler struct {
-	ctx context.Context
-	h   host.Host
-	dht *dht.IpfsDHT
+const crawlTag = "ipfs-crawl"
+
+// CrawlTagWeight is the connection manager weight of connections opened by
+// the crawl. It is negative so that crawl connections are trimmed before
+// untagged connections, such as those opened by the DHT.
+const CrawlTagWeight = -10
+
+// Host is the subset of the libp2p host used by the crawler.
+type Host interface {
+	Connect(ctx context.Context, pi pstore.PeerInfo) error
+	Network() inet.Network
+	Peerstore() pstore.Peerstore
+}
 
-	peers map[peer.ID]struct{}
-	work  chan pstore.PeerInfo
+var _ Host = (host.Host)(nil)
 
-	Discovered chan pstore.PeerInfo
+// ErrNoConns is set on records emitted on Failed when Connect reported
+// success but the host holds no connection to the peer.
+var ErrNoConns = errors.New("connected, but no conns to peer")
+
+type Crawler struct {
+	// accessed atomically; kept first for alignment
+	dropped    uint64
+	connects   uint64
+	failures   uint64
+	rounds     uint64
+	visits     uint64
+	revisits   uint64
+	idleRounds int64
+	workers    int64
+	busy       int64
+	active     int64
+	idleArmed  int32
+
+	ctx    context.Context
+	cancel func()
+	cfg    config
+	log    Logger
+	h      Host
+	dhts   []namedDHT
+
+	started time.Time
+
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+	drainOnce  sync.Once
+	draining   chan struct{}
+	retire     chan struct{}
+	dialLimit  *limiter
+	queryLimit *limiter
+	querySem   semaphore
+	dialSem    semaphore
+	metrics    *metrics
+	sinks      []sink
+	stream     *broadcaster
+	recent     *recentBuffer
+	connMgr    *connmgr.BasicConnMgr
+	churn      *churnTracker
+
+	pauseMx sync.Mutex
+	resumed chan struct{} // non-nil while paused
+
+	peers    *peerSet
+	previous []peer.ID
+	keys     *keySet
+	density  *density
+	work     *workQueue
+
+	// Records receives a record for every peer connected to, and Failed a
+	// record for every peer that could not be connected to. Records are
+	// dropped rather than stall the workers if a channel is full.
+	Records chan PeerRecord
+	Failed  chan PeerRecord
+
+	// Rounds receives a summary after each crawl round. Summaries are
+	// dropped if the channel is full.
+	Rounds chan RoundSummary
+
+	// Churn receives an event for every crawled peer that disconnects soon
+	// after connecting, if enabled with WithChurnTracking. Events are dropped
+	// if the channel is full.
+	Churn chan ChurnEvent
+
+	events chan Event
+
+	emitMx sync.RWMutex
+	closed bool
+	final  FinalSummary
 }
 
-func NewCrawler(ctx context.Context, h host.Host, dht *dht.IpfsDHT) *Crawler {
-	c := &Crawler{ctx: ctx, h: h, dht: dht,
-		peers:      make(map[peer.ID]struct{}),
-		work:       make(chan pstore.PeerInfo, WORKERS),
-		Discovered: make(chan pstore.PeerInfo, 256),
+// NewCrawler creates a crawler querying dht, which is usually a
+// *dht.IpfsDHT, and dialing discovered peers with h, which is usually a
+// host.Host. More DHTs can be crawled alongside dht with WithDHT.
+func NewCrawler(ctx context.Context, h Host, dht DHT, opts ...Option) (*Crawler, error) {
+	cfg := defaults()
+	for _, opt := range opts {
+		if err := opt(&cfg); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	var v visited = make(mapVisited)
+	detailed := true
+	if cfg.bloomSize > 0 {
+		v = newBloomVisited(cfg.bloomSize, cfg.bloomFP)
+		detailed = false
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if cfg.anchors == nil {
+		// RandomAnchors, retrying failed reads only while the crawl runs
+		cfg.anchors = func() (string, error) { return randomAnchor(ctx, randRead) }
+		if cfg.rand != nil {
+			cfg.anchors = func() (string, error) { return cfg.rand.anchor(ctx) }
+		}
+		if cfg.xorWalkBits > 0 {
+			cfg.anchors = xorWalk(cfg.xorWalkBits, cfg.anchors)
+		}
+	}
+	c := &Crawler{ctx: ctx, cancel: cancel, cfg: cfg, log: cfg.logger, h: h,
+		dhts:       append([]namedDHT{{PrimaryDHT, dht}}, cfg.dhts...),
+		started:    time.Now(),
+		dialLimit:  newLimiter(cfg.dialRate(), cfg.connectBurst),
+		queryLimit: newLimiter(cfg.queryRate, cfg.queryBurst),
+		peers:      newPeerSet(v, cfg.maxPeers, detailed),
+		draining:   make(chan struct{}),
+		retire:     make(chan struct{}),
+		work:       newWorkQueue(cfg.maxWorkers, cfg.score),
+		Records:    make(chan PeerRecord, cfg.recordsBuffer),
+		Failed:     make(chan PeerRecord, cfg.recordsBuffer),
+		Rounds:     make(chan RoundSummary, roundsBuffer),
+		Churn:      make(chan ChurnEvent, cfg.recordsBuffer),
+		events:     make(chan Event, cfg.recordsBuffer),
+	}
+
+	// Read the previous crawl before anything that needs undoing is set up.
+	if cfg.previousFile != "" {
+		ps, err := c.readState(cfg.previousFile)
+		if err != nil && !os.IsNotExist(err) {
+			cancel()
+			return nil, fmt.Errorf("error reading previous crawl %s: %s", cfg.previousFile, err)
+		}
+		c.previous = ps
+	}
+
+	m, err := newMetrics(cfg.registerer, func() float64 { return float64(c.work.len()) })
+	if err != nil {
+		cancel()
+		return nil, err
 	}
+	c.metrics = m
 
-	for i := 0; i < WORKERS; i++ {
-		go c.worker()
+	if cfg.dedupByKey {
+		c.keys = newKeySet()
+	}
+	if cfg.adaptiveAnchors {
+		c.density = &density{}
+		c.cfg.anchors = c.adaptiveAnchors(cfg.anchors)
 	}
 
-	return c
+	if cfg.maxQueries > 0 {
+		c.querySem = newSemaphore(cfg.maxQueries)
+	}
+	if cfg.maxDials > 0 {
+		c.dialSem = newSemaphore(cfg.maxDials)
+	}
+
+	c.stream = newBroadcaster()
+	c.sinks = append(c.sinks, c.stream)
+
+	if cfg.recentBuffer > 0 {
+		c.recent = newRecentBuffer(cfg.recentBuffer)
+		c.sinks = append(c.sinks, c.recent)
+	}
+
+	if cfg.sqliteDB != nil || cfg.sqlitePath != "" {
+		var s *sqliteSink
+		if cfg.sqliteDB != nil {
+			s, err = newSQLiteSink(cfg.sqliteDB, c.log)
+		} else {
+			s, err = openSQLiteSink(cfg.sqlitePath, c.log)
+		}
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		c.sinks = append(c.sinks, s)
+	}
+
+	if cfg.connMgr != nil {
+		c.connMgr = connmgr.NewConnManager(cfg.connMgr.low, cfg.connMgr.high, cfg.connMgr.grace)
+		h.Network().Notify(c.connMgr.Notifee())
+	}
+
+	if cfg.churnWindow > 0 {
+		c.churn = newChurnTracker(c, cfg.churnWindow)
+		h.Network().Notify(c.churn.notifiee)
+	}
+
+	if cfg.stateFile != "" {
+		c.loadState()
+		c.wg.Add(1)
+		go c.stateSaver()
+	}
+
+	if cfg.statsInterval > 0 {
+		c.wg.Add(1)
+		go c.statsReporter()
+	}
+
+	for i := 0; i < cfg.workers; i++ {
+		c.startWorker()
+	}
+	if cfg.maxWorkers > cfg.workers {
+		c.wg.Add(1)
+		go c.scaler()
+	}
+
+	return c, nil
 }
 
-func (c *Crawler) Crawl() {
-	anchor := make([]byte, 32)
+// Close stops the crawl, waits for the workers to exit and closes the
+// Records, Failed, Rounds, Churn and Events channels. It is safe to call
+// Close more than once.
+func (c *Crawler) Close() error {
+	c.cancel()
+	c.shutdown()
+	if c.connMgr != nil {
+		c.h.Network().StopNotify(c.connMgr.Notifee())
+	}
+	if c.churn != nil {
+		c.h.Network().StopNotify(c.churn.notifiee)
+	}
+	return nil
+}
+
+// drain stops the crawl from queueing new peers, lets the workers dial the
+// peers already queued and closes the record channels once they are done.
+func (c *Crawler) drain() {
+	c.drainOnce.Do(func() { close(c.draining) })
+	c.shutdown()
+}
+
+func (c *Crawler) shutdown() {
+	c.closeOnce.Do(func() {
+		c.wg.Wait()
+
+		c.emitMx.Lock()
+		defer c.emitMx.Unlock()
+		c.final = c.summary()
+		c.sendEvent(CrawlFinished{c.final})
+		c.closed = true
+		close(c.Records)
+		close(c.Failed)
+		close(c.Rounds)
+		close(c.Churn)
+		close(c.events)
+
+		for _, s := range c.sinks {
+			if err := s.close(); err != nil {
+				c.log.Errorf("Error closing sink: %s", err.Error())
+			}
+		}
+	})
+}
+
+// Crawl runs the crawl until the context is cancelled, returning the context
+// error, or until the WithMaxPeers or WithSaturationRounds limits are reached,
+// returning nil. Failed DHT queries only skip the current anchor; Crawl
+// returns early only if it cannot generate anchors. Any seed peers are
+// crawled before the first anchor.
+func (c *Crawler) Crawl() error {
+	if len(c.cfg.seeds) > 0 {
+		c.crawlSeeds(c.ctx)
+		if c.finished() {
+			return nil
+		}
+	}
+
 	for {
-		_, err := crand.Read(anchor)
+		if !c.waitResumed() {
+			return c.ctx.Err()
+		}
+
+		err := c.CrawlRound(c.ctx)
+		if c.ctx.Err() != nil {
+			return c.ctx.Err()
+		}
+
+		switch err.(type) {
+		case nil:
+		case anchorError:
+			return err
+		default:
+			c.log.Warnf("Error crawling round: %s", err.Error())
+		}
+
+		if c.finished() {
+			return nil
+		}
+
+		if !c.sleep(c.cfg.anchorInterval) {
+			return c.ctx.Err()
+		}
+	}
+}
+
+// anchorError is returned by CrawlRound when it fails to generate an anchor.
+type anchorError struct {
+	err error
+}
+
+func (e anchorError) Error() string {
+	return "error generating anchor: " + e.err.Error()
+}
+
+// CrawlRound crawls from the anchors of a round, one unless
+// WithAnchorsPerRound is set, and returns once the traversals from them
+// complete, for callers that pace the crawl themselves. The round stops early
+// if ctx is done or the crawler is closed. It counts as idle, towards
+// WithSaturationRounds, only if none of its traversals found new peers.
+func (c *Crawler) CrawlRound(ctx context.Context) error {
+	ctx, cancel := c.roundContext(ctx)
+	defer cancel()
+
+	anchors := make([]string, 0, c.cfg.anchorsPerRound)
+	for i := 0; i < c.cfg.anchorsPerRound; i++ {
+		anchor, err := c.cfg.anchors()
 		if err != nil {
-			log.Fatal(err)
+			return anchorError{err}
 		}
+		anchors = append(anchors, anchor)
+	}
+
+	errs := make([]error, len(anchors))
+	news := make([]int, len(anchors))
+	crawled := make([]bool, len(anchors))
+	var wg sync.WaitGroup
+	for i, anchor := range anchors {
+		wg.Add(1)
+		go func(i int, anchor string) {
+			defer wg.Done()
+			var err error
+			news[i], crawled[i], err = c.crawlFromAnchor(ctx, anchor)
+			if err != nil {
+				errs[i] = fmt.Errorf("error crawling from anchor %s: %s", anchor, err)
+			}
+		}(i, anchor)
+	}
+	wg.Wait()
+
+	newPeers, traversed := 0, false
+	for i := range anchors {
+		newPeers += news[i]
+		traversed = traversed || crawled[i]
+	}
+	if traversed {
+		c.countRound(newPeers)
+	}
 
-		str := base64.RawStdEncoding.EncodeToString(anchor)
-		c.crawlFromAnchor(str)
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
 
+// roundContext derives a context from ctx that is also cancelled when the
+// crawler is closed.
+func (c *Crawler) roundContext(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
 		select {
-		case <-time.After(5 * time.Second):
 		case <-c.ctx.Done():
-			return
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+	return ctx, cancel
+}
+
+// Seen returns true if the crawl has already visited p.
+func (c *Crawler) Seen(p peer.ID) bool {
+	return c.peers.seen(p)
+}
+
+// PeerMeta returns when the crawl first and last saw p. It returns false if
+// p hasn't been visited, or if visited peers are tracked with
+// WithVisitedBloom.
+func (c *Crawler) PeerMeta(p peer.ID) (PeerMeta, bool) {
+	return c.peers.meta(p)
+}
+
+// SnapshotPeers returns the peers visited so far. It returns nil if visited
+// peers are tracked with WithVisitedBloom.
+func (c *Crawler) SnapshotPeers() []peer.ID {
+	return c.peers.list()
+}
+
+// Graph returns the peers each visited peer reported being connected to. The
+// graph is not recorded if visited peers are tracked with WithVisitedBloom.
+func (c *Crawler) Graph() map[peer.ID][]peer.ID {
+	return c.peers.graph()
+}
+
+// PeerCount returns the number of peers visited so far.
+func (c *Crawler) PeerCount() int {
+	return c.peers.len()
+}
+
+// finished drains the crawler if the WithMaxPeers limit has been reached or
+// the crawl has saturated, as set by WithSaturationRounds.
+func (c *Crawler) finished() bool {
+	switch {
+	case c.peers.full():
+		c.log.Infof("Reached the limit of %d peers", c.cfg.maxPeers)
+	case c.cfg.saturationRounds > 0 && atomic.LoadInt64(&c.idleRounds) >= int64(c.cfg.saturationRounds):
+		c.log.Infof("No new peers found in %d rounds", c.cfg.saturationRounds)
+	default:
+		return false
 	}
+
+	c.drain()
+	return true
 }
 
-func (c *Crawler) crawlFromAnchor(key string) {
-	// fmt.Printf("Crawling from anchor %s\n", key)
+// crawlSeeds crawls from the peers given with WithSeedPeers.
+func (c *Crawler) crawlSeeds(ctx context.Context) {
+	c.log.Infof("Crawling from %d seed peers", len(c.cfg.seeds))
 
-	ctx, cancel := context.WithTimeout(c.ctx, 60*time.Second)
-	pch, err := c.dht.GetClosestPeers(ctx, key)
+	ps := make([]peer.ID, 0, len(c.cfg.seeds))
+	for _, pi := range c.cfg.seeds {
+		c.h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.TempAddrTTL)
+		ps = append(ps, pi.ID)
+	}
 
-	if err != nil {
-		log.Fatal(err)
+	r := &round{ctx: ctx, dht: c.dhts[0]}
+	c.traverse(ps, r)
+	c.countRound(c.endRound(r))
+}
+
+// crawlFromAnchor traverses each DHT in turn from the peers closest to key.
+// It returns the number of new peers found, whether any DHT was traversed,
+// and the last query error, having crawled the DHTs that succeeded.
+func (c *Crawler) crawlFromAnchor(ctx context.Context, key string) (newPeers int, crawled bool, err error) {
+	for _, d := range c.dhts {
+		if ctx.Err() != nil {
+			break
+		}
+		c.log.Infof("Crawling from anchor %s in the %s DHT", key, d.name)
+
+		ps, qerr := c.anchorPeers(ctx, d.dht, key)
+		if qerr != nil {
+			c.emitEvent(QueryError{Op: "GetClosestPeers", Key: key, Err: qerr})
+			err = qerr
+			continue
+		}
+
+		c.log.Debugf("Found %d peers", len(ps))
+		r := &round{ctx: ctx, anchor: key, dht: d}
+		c.traverse(ps, r)
+		newPeers += c.endRound(r)
+		crawled = true
+	}
+
+	return newPeers, crawled, err
+}
+
+// endRound reports the traversal r, returning the number of new peers it
+// found.
+func (c *Crawler) endRound(r *round) int {
+	newPeers, reseen := r.counts()
+	sum := RoundSummary{
+		Anchor:     r.anchor,
+		DHT:        r.dht.name,
+		NewPeers:   newPeers,
+		SeenPeers:  reseen,
+		TotalPeers: c.peers.len(),
+	}
+	c.log.Infof("Finished round: %d new peers, %d seen again, %d total", sum.NewPeers, sum.SeenPeers, sum.TotalPeers)
+
+	c.emitMx.RLock()
+	defer c.emitMx.RUnlock()
+	if !c.closed {
+		select {
+		case c.Rounds <- sum:
+		default:
+		}
+		c.sendEvent(RoundComplete{sum})
 	}
+	return newPeers
+}
+
+// countRound counts a round that found newPeers towards the summary and
+// WithSaturationRounds.
+func (c *Crawler) countRound(newPeers int) {
+	atomic.AddUint64(&c.rounds, 1)
+	if newPeers == 0 {
+		atomic.AddInt64(&c.idleRounds, 1)
+	} else {
+		atomic.StoreInt64(&c.idleRounds, 0)
+	}
+}
 
-	var ps []peer.ID
-	for p := range pch {
-		ps = append(ps, p)
+// traverse crawls breadth-first from ps, expanding each level of the peer
+// graph before moving on to the next.
+func (c *Crawler) traverse(ps []peer.ID, r *round) {
+	for depth := 0; len(ps) > 0 && r.ctx.Err() == nil && !c.peers.full(); depth++ {
+		last := c.cfg.maxDepth >= 0 && depth >= c.cfg.maxDepth
+		ps = c.expand(ps, r, !last)
 	}
-	cancel()
+}
+
+// expand crawls the peers in ps using a bounded number of goroutines and, if
+// neighbors is set, returns the next level of unvisited peers.
+func (c *Crawler) expand(ps []peer.ID, r *round, neighbors bool) []peer.ID {
+	var mx sync.Mutex
+	var next []peer.ID
+	queued := make(map[peer.ID]struct{})
 
-	// fmt.Printf("Found %d peers\n", len(ps))
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, c.cfg.expanders)
+
+loop:
 	for _, p := range ps {
-		c.crawlPeer(p)
+		if c.peers.full() {
+			break
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-r.ctx.Done():
+			break loop
+		}
+
+		wg.Add(1)
+		go func(p peer.ID) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			var ns []peer.ID
+			c.safely("crawling "+p.Pretty(), func() {
+				ns = c.crawlPeer(p, r, neighbors)
+			})
+
+			mx.Lock()
+			defer mx.Unlock()
+			for _, n := range ns {
+				if _, ok := queued[n]; ok {
+					continue
+				}
+				if c.peers.touch(n) {
+					r.encountered(n)
+					if !c.peers.due(n, c.cfg.refreshInterval) {
+						c.revisited()
+						continue
+					}
+				}
+				queued[n] = struct{}{}
+				next = append(next, n)
+			}
+		}(p)
 	}
+
+	wg.Wait()
+	return next
 }
 
-func (c *Crawler) crawlPeer(p peer.ID) {
-	_, ok := c.peers[p]
-	if ok {
-		return
+// crawlPeer looks up p, queues it for connection and, if neighbors is set,
+// returns the peers it is connected to.
+func (c *Crawler) crawlPeer(p peer.ID, r *round, neighbors bool) []peer.ID {
+	if c.blacklisted(p) {
+		c.log.Debugf("Skipping blacklisted peer %s", p.Pretty())
+		return nil
 	}
 
-	// fmt.Printf("Crawling peer %s\n", p.Pretty())
+	claimed, refreshing := c.peers.claim(p, c.cfg.refreshInterval)
+	if !claimed {
+		if c.peers.touch(p) {
+			r.encountered(p)
+			c.revisited()
+		}
+		return nil
+	}
+	defer c.peers.release(p)
 
-	ctx, cancel := context.WithTimeout(c.ctx, 60*time.Second)
-	pi, err := c.dht.FindPeer(ctx, p)
-	cancel()
+	c.log.Debugf("Crawling peer %s", p.Pretty())
+	ctx, span := c.cfg.tracer.Start(r.ctx, "crawlPeer",
+		Attr{"peer.id", p.Pretty()}, Attr{"anchor", r.anchor})
 
+	start := time.Now()
+	pi, err := c.findPeer(ctx, r.dht.dht, p)
+	span.AddEvent("FindPeer", durationMs(time.Since(start)))
 	if err != nil {
-		// fmt.Printf("Peer not found %s: %s\n", p.Pretty(), err.Error())
-		return
+		c.log.Debugf("Peer not found %s: %s", p.Pretty(), err.Error())
+		c.emitEvent(QueryError{Op: "FindPeer", Peer: p, Err: err})
+		endSpan(span, "not found", err)
+		return nil
+	}
+	pi.Addrs = c.familyAddrs(pi.Addrs)
+
+	if refreshing {
+		c.log.Debugf("Refreshing peer %s", p.Pretty())
+		c.peers.touch(p)
+		r.encountered(p)
+	} else {
+		if !c.peers.markSeen(p, r.anchor) {
+			if c.peers.seen(p) {
+				c.revisited()
+			}
+			endSpan(span, "seen", nil)
+			return nil
+		}
+		atomic.AddUint64(&c.visits, 1)
+		c.metrics.discovered.Inc()
+		if c.density != nil {
+			c.density.add(p)
+		}
+		r.discovered(p)
 	}
 
-	c.peers[p] = struct{}{}
-	select {
-	case c.work <- pi:
-	case <-c.ctx.Done():
-		return
+	// look the neighbors up first so that their number goes on the record
+	var ns []peer.ID
+	if neighbors {
+		ns = c.neighbors(p, r)
 	}
+	rec := PeerRecord{PeerInfo: pi, Anchor: r.anchor, DHT: r.dht.name, RoutingTableSize: len(ns)}
 
-	ctx, cancel = context.WithTimeout(c.ctx, 60*time.Second)
-	pch, err := c.dht.FindPeersConnectedToPeer(ctx, p)
+	switch {
+	case !c.accept(pi):
+		endSpan(span, "filtered", nil)
+	case c.cfg.dryRun:
+		rec.DiscoveredAt = time.Now()
+		c.locate(&rec)
+		c.record(rec)
+		endSpan(span, "recorded", nil)
+	default:
+		select {
+		case c.work.slots <- struct{}{}:
+			atomic.StoreInt32(&c.idleArmed, 1)
+			c.work.push(rec)
+			endSpan(span, "queued", nil)
+		case <-c.draining:
+			endSpan(span, "draining", nil)
+			return nil
+		case <-r.ctx.Done():
+			endSpan(span, "cancelled", r.ctx.Err())
+			return nil
+		}
+	}
 
+	return c.sampleNeighbors(ns)
+}
+
+// neighbors returns the peers p is connected to, recording them in the
+// graph.
+func (c *Crawler) neighbors(p peer.ID, r *round) []peer.ID {
+	ps, err := c.connectedPeers(r.ctx, r.dht.dht, p)
 	if err != nil {
-		// fmt.Printf("Can't find peers connected to peer %s: %s\n", p.Pretty(), err.Error())
-		cancel()
-		return
+		c.log.Debugf("Can't find peers connected to peer %s: %s", p.Pretty(), err.Error())
+		c.emitEvent(QueryError{Op: "FindPeersConnectedToPeer", Peer: p, Err: err})
+		return nil
 	}
 
-	var ps []peer.ID
-	for pip := range pch {
-		ps = append(ps, pip.ID)
-	}
-	cancel()
+	c.log.Debugf("Peer %s is connected to %d peers", p.Pretty(), len(ps))
+	c.peers.setEdges(p, ps)
 
-	// fmt.Printf("Peer %s is connected to %d peers\n", p.Pretty(), len(ps))
+	return ps
+}
 
-	for _, p := range ps {
-		c.crawlPeer(p)
+// revisited counts an encounter of an already visited peer that the
+// traversal skips.
+func (c *Crawler) revisited() {
+	atomic.AddUint64(&c.revisits, 1)
+	c.metrics.revisited.Inc()
+}
+
+// sampleNeighbors returns a random sample of ns if it has more peers than
+// WithMaxNeighborsPerPeer allows.
+func (c *Crawler) sampleNeighbors(ns []peer.ID) []peer.ID {
+	n := c.cfg.maxNeighbors
+	if n == 0 || len(ns) <= n {
+		return ns
+	}
+
+	// ns is also the recorded graph edge list, so shuffle a copy
+	ns = append([]peer.ID(nil), ns...)
+	for i := 0; i < n; i++ {
+		j := i + int(c.int63n(int64(len(ns)-i)))
+		ns[i], ns[j] = ns[j], ns[i]
 	}
+	return ns[:n]
 }
 
 func (c *Crawler) worker() {
+	defer c.wg.Done()
+	defer atomic.AddInt64(&c.workers, -1)
 	for {
 		select {
-		case pi, ok := <-c.work:
-			if !ok {
+		case <-c.work.ready:
+			if !c.dial(c.next()) {
 				return
 			}
-			// add a bit of delay to avoid connection storms
-			dt := mrand.Intn(60000)
-			time.Sleep(time.Duration(dt) * time.Millisecond)
-			c.tryConnect(pi)
+
+		case <-c.retire:
+			return
+
+		case <-c.draining:
+			for {
+				select {
+				case <-c.work.ready:
+					if !c.dial(c.next()) {
+						return
+					}
+				default:
+					return
+				}
+			}
 
 		case <-c.ctx.Done():
 			return
@@ -148,39 +749,257 @@ func (c *Crawler) worker() {
 	}
 }
 
-func (c *Crawler) tryConnect(pi pstore.PeerInfo) {
+// next pops the next queued peer, counting the worker busy before the pop so
+// that the crawl cannot look idle between the pop and the dial.
+func (c *Crawler) next() PeerRecord {
+	atomic.AddInt64(&c.busy, 1)
+	return c.work.pop()
+}
+
+// dial connects to a peer taken with next, returning false if the crawler is
+// shutting down.
+func (c *Crawler) dial(rec PeerRecord) bool {
+	defer c.doneDialing()
+
+	if !c.waitResumed() {
+		return false
+	}
+	// pace dials across workers to avoid connection storms
+	if err := c.dialLimit.Wait(c.ctx); err != nil {
+		return false
+	}
+	atomic.AddInt64(&c.active, 1)
+	defer atomic.AddInt64(&c.active, -1)
+	c.safely("dialing "+rec.PeerInfo.ID.Pretty(), func() {
+		c.tryConnect(rec)
+	})
+	return true
+}
+
+// inBackoff reports whether a dial failed because the swarm is backing off
+// dialing the peer, unless backoff handling is disabled.
+func (c *Crawler) inBackoff(err error) bool {
+	return c.cfg.backoffHandling && err == swarm.ErrDialBackoff
+}
+
+// backoffDelay returns how long to wait before the given retry of a dial in
+// backoff: a random duration of up to base doubled for each previous retry,
+// capped at max.
+func (c *Crawler) backoffDelay(attempt int) time.Duration {
+	dt := c.cfg.backoffBase
+	for i := 1; i < attempt && dt < c.cfg.backoffMax; i++ {
+		dt *= 2
+	}
+	if dt > c.cfg.backoffMax {
+		dt = c.cfg.backoffMax
+	}
+	return time.Duration(c.int63n(int64(dt) + 1))
+}
+
+func (c *Crawler) tryConnect(rec PeerRecord) {
+	pi := rec.PeerInfo
+	if addrs := c.resolveAddrs(pi); addrs != nil {
+		addrs = c.familyAddrs(addrs)
+		rec.ResolvedAddrs = addrs
+		pi.Addrs = addrs
+	}
 	backoff := 0
-	var ctx context.Context
-	var cancel func()
 
-again:
-	// fmt.Printf("Connecting to %s (%d)\n", pi.ID.Pretty(), len(pi.Addrs))
-	ctx, cancel = context.WithTimeout(c.ctx, 60*time.Second)
+	_, span := c.cfg.tracer.Start(c.ctx, "tryConnect",
+		Attr{"peer.id", pi.ID.Pretty()}, Attr{"addrs", len(pi.Addrs)})
+
+	release, err := c.acquireDial()
+	if err != nil {
+		endSpan(span, "cancelled", err)
+		return
+	}
+	defer release()
 
-	err := c.h.Connect(ctx, pi)
-	cancel()
+again:
+	c.log.Debugf("Connecting to %s (%d)", pi.ID.Pretty(), len(pi.Addrs))
+	start := time.Now()
+	err = c.connect(pi)
+	latency := time.Since(start)
 
 	switch {
-	case err == swarm.ErrDialBackoff:
+	case c.inBackoff(err):
 		backoff++
-		if backoff < 7 {
-			dt := 1000 + mrand.Intn(backoff*10000)
-			// fmt.Printf("Backing off dialing %s\n", pi.ID.Pretty())
-			time.Sleep(time.Duration(dt) * time.Millisecond)
+		if backoff <= c.cfg.dialRetries {
+			dt := c.backoffDelay(backoff)
+			c.log.Debugf("Backing off dialing %s", pi.ID.Pretty())
+			span.AddEvent("dial backoff", Attr{"attempt", backoff})
+			if !c.sleep(dt) {
+				endSpan(span, "cancelled", nil)
+				return
+			}
 			goto again
 		} else {
-			// fmt.Printf("FAILED to connect to %s; giving up from dial backoff\n", pi.ID.Pretty())
+			c.log.Debugf("FAILED to connect to %s; giving up from dial backoff", pi.ID.Pretty())
+			c.metrics.backoffGiveUps.Inc()
+			c.metrics.connectFailed.Inc()
+			atomic.AddUint64(&c.failures, 1)
+
+			rec.DiscoveredAt = time.Now()
+			rec.Err = err
+			rec.DialBackoff = true
+			c.emitTo(c.Failed, rec)
+			endSpan(span, "backoff", err, Attr{"retries", backoff - 1})
 		}
 	case err != nil:
-		// fmt.Printf("FAILED to connect to %s: %s", pi.ID.Pretty(), err.Error())
+		c.log.Debugf("FAILED to connect to %s: %s", pi.ID.Pretty(), err.Error())
+		c.metrics.connectFailed.Inc()
+		atomic.AddUint64(&c.failures, 1)
+
+		rec.DiscoveredAt = time.Now()
+		rec.Err = err
+		c.emitTo(c.Failed, rec)
+		endSpan(span, "failed", err, durationMs(latency), Attr{"retries", backoff})
+	case c.h.Network().Connectedness(pi.ID) != inet.Connected:
+		// Connect succeeded but the swarm holds no connection, so nothing
+		// we'd record about the peer can be trusted; treat it as a failure.
+		c.log.Warnf("Supposedly connected, but no conns to peer %s", pi.ID.Pretty())
+		c.metrics.connectFailed.Inc()
+		atomic.AddUint64(&c.failures, 1)
+
+		rec.DiscoveredAt = time.Now()
+		rec.Err = ErrNoConns
+		c.emitTo(c.Failed, rec)
+		endSpan(span, "failed", ErrNoConns, durationMs(latency), Attr{"retries", backoff})
 	default:
-		// fmt.Printf("CONNECTED to %s", pi.ID.Pretty())
+		release()
+		c.log.Debugf("CONNECTED to %s", pi.ID.Pretty())
+		c.metrics.connected.Inc()
+		atomic.AddUint64(&c.connects, 1)
+		if c.connMgr != nil {
+			c.connMgr.TagPeer(pi.ID, crawlTag, CrawlTagWeight)
+		}
+		if c.churn != nil {
+			c.churn.track(pi.ID)
+		}
+
+		rec.DiscoveredAt = time.Now()
+		rec.DialLatency = latency
+
+		c.waitIdentify(pi.ID)
+		rec.AgentVersion = c.agentVersion(pi.ID)
+		rec.Client = ClassifyAgent(rec.AgentVersion)
+		rec.Protocols = c.protocols(pi.ID)
+		id := c.identify(pi.ID)
+		rec.AdvertisedAddrs = advertisedAddrs(id)
+		rec.ObservedAddrs = observedAddrs(id)
+		if c.cfg.ping {
+			rec.PingRTT = c.pingRTT(pi.ID)
+		}
+		rec.Reachability = c.reachability(pi)
+		c.locate(&rec)
 
-		c.Discovered <- pi
+		c.record(rec)
+		endSpan(span, "connected", nil, durationMs(latency), Attr{"retries", backoff})
 
-		conns := c.h.Network().ConnsToPeer(pi.ID)
-		if len(conns) == 0 {
-			fmt.Println("ERROR: supposedly connected, but no conns to peer", pi.ID.Pretty())
+		if c.cfg.disconnect {
+			if c.churn != nil {
+				c.churn.forget(pi.ID)
+			}
+			if err := c.h.Network().ClosePeer(pi.ID); err != nil {
+				c.log.Debugf("Error disconnecting from %s: %s", pi.ID.Pretty(), err.Error())
+			}
 		}
 	}
 }
+
+// sleep waits for d, returning false if the crawler is closed first.
+func (c *Crawler) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
+// acquireDial waits for a dial slot if WithMaxInflightDials is set. The
+// returned function releases the slot and may be called more than once.
+func (c *Crawler) acquireDial() (func(), error) {
+	if c.dialSem == nil {
+		return func() {}, nil
+	}
+	if err := c.dialSem.Acquire(c.ctx); err != nil {
+		return nil, err
+	}
+
+	var once sync.Once
+	return func() { once.Do(func() { c.dialSem.Release() }) }, nil
+}
+
+func (c *Crawler) connect(pi pstore.PeerInfo) error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.dialTimeout)
+	defer cancel()
+
+	return c.h.Connect(ctx, pi)
+}
+
+// record emits a connected peer's record on Records and to the sinks.
+func (c *Crawler) record(rec PeerRecord) {
+	if c.duplicateKey(rec.PeerInfo.ID) {
+		return
+	}
+	rec.Connectedness = c.h.Network().Connectedness(rec.PeerInfo.ID)
+
+	c.emitMx.RLock()
+	defer c.emitMx.RUnlock()
+	if c.closed {
+		return
+	}
+
+	for _, s := range c.sinks {
+		s.put(rec)
+	}
+	c.send(c.Records, rec)
+	c.sendEvent(PeerDiscovered{rec})
+}
+
+func (c *Crawler) emitChurn(ev ChurnEvent) {
+	c.emitMx.RLock()
+	defer c.emitMx.RUnlock()
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.Churn <- ev:
+	default:
+	}
+}
+
+// emitTo sends rec on ch without blocking, dropping it if the consumer has
+// fallen behind.
+func (c *Crawler) emitTo(ch chan PeerRecord, rec PeerRecord) {
+	rec.Connectedness = c.h.Network().Connectedness(rec.PeerInfo.ID)
+
+	c.emitMx.RLock()
+	defer c.emitMx.RUnlock()
+	if c.closed {
+		return
+	}
+
+	c.send(ch, rec)
+	c.sendEvent(PeerFailed{rec})
+}
+
+func (c *Crawler) send(ch chan PeerRecord, rec PeerRecord) {
+	select {
+	case ch <- rec:
+	default:
+		atomic.AddUint64(&c.dropped, 1)
+		c.metrics.dropped.Inc()
+	}
+}
+
+// Dropped returns the number of records dropped because Records or Failed was
+// full.
+func (c *Crawler) Dropped() uint64 {
+	return atomic.LoadUint64(&c.dropped)
+}
@@ -0,0 +1,1786 @@
+package crawl
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	mrand "math/rand"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	pstoremem "github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	swarm "github.com/libp2p/go-libp2p-swarm"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// testPeer returns the peer ID numbered n in a scripted graph. It is a
+// well-formed SHA-256 multihash so that it survives encoding.
+func testPeer(n int) peer.ID {
+	h := sha256.Sum256([]byte(strconv.Itoa(n)))
+	return peer.ID(append([]byte{0x12, 0x20}, h[:]...))
+}
+
+// testAddr returns a public TCP address unique to n.
+func testAddr(n int) ma.Multiaddr {
+	return mustAddr(fmt.Sprintf("/ip4/8.%d.%d.%d/tcp/4001", n>>16&0xff, n>>8&0xff, n&0xff))
+}
+
+func mustAddr(s string) ma.Multiaddr {
+	a, err := ma.NewMultiaddr(s)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// mockDHT answers queries from a scripted peer graph: GetClosestPeers
+// returns the peers set with closestTo for the key, or the roots, FindPeer a
+// peer's addresses and FindPeersConnectedToPeer its neighbors. Peers without
+// addresses are not found. If query is set, it is called with the name of
+// each query before it is answered, and fails the query if it returns an
+// error.
+type mockDHT struct {
+	roots   []peer.ID
+	closest map[string][]peer.ID
+	addrs   map[peer.ID][]ma.Multiaddr
+	edges   map[peer.ID][]peer.ID
+	query   func(ctx context.Context, op string) error
+
+	mx        sync.Mutex
+	keys      []string
+	calls     []string
+	findPeers map[peer.ID]int
+}
+
+// newMockDHT scripts a graph in which each peer numbered in edges is
+// connected to the peers it maps to, and the peers closest to any key are
+// those numbered roots. Every peer gets an address from testAddr.
+func newMockDHT(edges map[int][]int, roots ...int) *mockDHT {
+	d := &mockDHT{
+		addrs:     make(map[peer.ID][]ma.Multiaddr),
+		edges:     make(map[peer.ID][]peer.ID),
+		findPeers: make(map[peer.ID]int),
+	}
+	for _, n := range roots {
+		d.roots = append(d.roots, d.add(n))
+	}
+	for n, ns := range edges {
+		p := d.add(n)
+		for _, m := range ns {
+			d.edges[p] = append(d.edges[p], d.add(m))
+		}
+	}
+	return d
+}
+
+// add gives the peer numbered n an address, returning its ID.
+func (d *mockDHT) add(n int) peer.ID {
+	p := testPeer(n)
+	if _, ok := d.addrs[p]; !ok {
+		d.addrs[p] = []ma.Multiaddr{testAddr(n)}
+	}
+	return p
+}
+
+// closestTo makes the peers numbered ns the closest to key.
+func (d *mockDHT) closestTo(key string, ns ...int) {
+	if d.closest == nil {
+		d.closest = make(map[string][]peer.ID)
+	}
+	for _, n := range ns {
+		d.closest[key] = append(d.closest[key], d.add(n))
+	}
+}
+
+// setAddrs replaces the addresses of the peer numbered n.
+func (d *mockDHT) setAddrs(n int, addrs ...string) {
+	d.addrs[testPeer(n)] = nil
+	for _, a := range addrs {
+		d.addrs[testPeer(n)] = append(d.addrs[testPeer(n)], mustAddr(a))
+	}
+}
+
+func (d *mockDHT) GetClosestPeers(ctx context.Context, key string) (<-chan peer.ID, error) {
+	d.mx.Lock()
+	d.keys = append(d.keys, key)
+	d.calls = append(d.calls, "GetClosestPeers")
+	d.mx.Unlock()
+
+	if err := d.answer(ctx, "GetClosestPeers"); err != nil {
+		return nil, err
+	}
+
+	ps, ok := d.closest[key]
+	if !ok {
+		ps = d.roots
+	}
+	ch := make(chan peer.ID, len(ps))
+	for _, p := range ps {
+		ch <- p
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (d *mockDHT) FindPeer(ctx context.Context, p peer.ID) (pstore.PeerInfo, error) {
+	d.mx.Lock()
+	d.findPeers[p]++
+	d.calls = append(d.calls, "FindPeer "+p.Pretty())
+	d.mx.Unlock()
+
+	if err := d.answer(ctx, "FindPeer"); err != nil {
+		return pstore.PeerInfo{}, err
+	}
+
+	addrs, ok := d.addrs[p]
+	if !ok {
+		return pstore.PeerInfo{}, fmt.Errorf("peer %s not found", p.Pretty())
+	}
+	return pstore.PeerInfo{ID: p, Addrs: addrs}, nil
+}
+
+func (d *mockDHT) FindPeersConnectedToPeer(ctx context.Context, p peer.ID) (<-chan *pstore.PeerInfo, error) {
+	if err := d.answer(ctx, "FindPeersConnectedToPeer"); err != nil {
+		return nil, err
+	}
+
+	ns := d.edges[p]
+	ch := make(chan *pstore.PeerInfo, len(ns))
+	for _, n := range ns {
+		ch <- &pstore.PeerInfo{ID: n, Addrs: d.addrs[n]}
+	}
+	close(ch)
+	return ch, nil
+}
+
+func (d *mockDHT) answer(ctx context.Context, op string) error {
+	if d.query == nil {
+		return nil
+	}
+	return d.query(ctx, op)
+}
+
+// queried returns the keys GetClosestPeers was called with, in order.
+func (d *mockDHT) queried() []string {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return append([]string(nil), d.keys...)
+}
+
+// history returns the GetClosestPeers and FindPeer queries made, in order.
+// FindPeer queries are followed by the peer looked up.
+func (d *mockDHT) history() []string {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return append([]string(nil), d.calls...)
+}
+
+// lookups returns the number of times p was looked up with FindPeer.
+func (d *mockDHT) lookups(p peer.ID) int {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.findPeers[p]
+}
+
+// mockHost counts the Connect attempts made to each peer. Connect succeeds
+// unless dial is set, in which case dial decides the outcome. If noConns is
+// set, successful connects leave no connection to the peer behind.
+type mockHost struct {
+	ps      pstore.Peerstore
+	net     *mockNetwork
+	dial    func(ctx context.Context, pi pstore.PeerInfo) error
+	noConns bool
+
+	mx       sync.Mutex
+	attempts map[peer.ID]int
+}
+
+func newMockHost() *mockHost {
+	return &mockHost{
+		ps:       pstoremem.NewPeerstore(),
+		net:      newMockNetwork(),
+		attempts: make(map[peer.ID]int),
+	}
+}
+
+func (h *mockHost) Connect(ctx context.Context, pi pstore.PeerInfo) error {
+	h.mx.Lock()
+	h.attempts[pi.ID]++
+	h.mx.Unlock()
+
+	if h.dial != nil {
+		if err := h.dial(ctx, pi); err != nil {
+			return err
+		}
+	}
+	if !h.noConns {
+		h.net.connect(pi)
+	}
+	return nil
+}
+
+// connects returns the number of Connect attempts made to p.
+func (h *mockHost) connects(p peer.ID) int {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	return h.attempts[p]
+}
+
+// totalConnects returns the number of Connect attempts made to any peer.
+func (h *mockHost) totalConnects() int {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	n := 0
+	for _, a := range h.attempts {
+		n += a
+	}
+	return n
+}
+
+func (h *mockHost) Network() inet.Network       { return h.net }
+func (h *mockHost) Peerstore() pstore.Peerstore { return h.ps }
+
+// mockNetwork tracks the connections made by a mockHost. Connectedness
+// reports the state set in reported for the peers it holds. The methods of
+// inet.Network the crawler doesn't use panic.
+type mockNetwork struct {
+	inet.Network
+	reported map[peer.ID]inet.Connectedness
+
+	mx        sync.Mutex
+	conns     map[peer.ID]*mockConn
+	notifiees map[inet.Notifiee]struct{}
+}
+
+func newMockNetwork() *mockNetwork {
+	return &mockNetwork{
+		conns:     make(map[peer.ID]*mockConn),
+		notifiees: make(map[inet.Notifiee]struct{}),
+	}
+}
+
+func (n *mockNetwork) connect(pi pstore.PeerInfo) {
+	c := &mockConn{net: n, peer: pi.ID}
+	if len(pi.Addrs) > 0 {
+		c.remote = pi.Addrs[0]
+	}
+
+	n.mx.Lock()
+	if _, ok := n.conns[pi.ID]; ok {
+		n.mx.Unlock()
+		return
+	}
+	n.conns[pi.ID] = c
+	ns := n.notifieesLocked()
+	n.mx.Unlock()
+
+	for _, nn := range ns {
+		nn.Connected(n, c)
+	}
+}
+
+func (n *mockNetwork) disconnect(c *mockConn) {
+	n.mx.Lock()
+	if n.conns[c.peer] != c {
+		n.mx.Unlock()
+		return
+	}
+	delete(n.conns, c.peer)
+	ns := n.notifieesLocked()
+	n.mx.Unlock()
+
+	for _, nn := range ns {
+		nn.Disconnected(n, c)
+	}
+}
+
+func (n *mockNetwork) notifieesLocked() []inet.Notifiee {
+	ns := make([]inet.Notifiee, 0, len(n.notifiees))
+	for nn := range n.notifiees {
+		ns = append(ns, nn)
+	}
+	return ns
+}
+
+func (n *mockNetwork) Connectedness(p peer.ID) inet.Connectedness {
+	if c, ok := n.reported[p]; ok {
+		return c
+	}
+
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	if _, ok := n.conns[p]; ok {
+		return inet.Connected
+	}
+	return inet.NotConnected
+}
+
+func (n *mockNetwork) ConnsToPeer(p peer.ID) []inet.Conn {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	if c, ok := n.conns[p]; ok {
+		return []inet.Conn{c}
+	}
+	return nil
+}
+
+func (n *mockNetwork) Peers() []peer.ID {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	ps := make([]peer.ID, 0, len(n.conns))
+	for p := range n.conns {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+func (n *mockNetwork) ClosePeer(p peer.ID) error {
+	n.mx.Lock()
+	c, ok := n.conns[p]
+	n.mx.Unlock()
+	if ok {
+		c.Close()
+	}
+	return nil
+}
+
+func (n *mockNetwork) Notify(nn inet.Notifiee) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	n.notifiees[nn] = struct{}{}
+}
+
+func (n *mockNetwork) StopNotify(nn inet.Notifiee) {
+	n.mx.Lock()
+	defer n.mx.Unlock()
+	delete(n.notifiees, nn)
+}
+
+// mockConn is a connection of a mockNetwork. The methods of inet.Conn the
+// crawler doesn't use panic.
+type mockConn struct {
+	inet.Conn
+
+	net    *mockNetwork
+	peer   peer.ID
+	remote ma.Multiaddr
+}
+
+func (c *mockConn) RemotePeer() peer.ID           { return c.peer }
+func (c *mockConn) RemoteMultiaddr() ma.Multiaddr { return c.remote }
+
+func (c *mockConn) Close() error {
+	c.net.disconnect(c)
+	return nil
+}
+
+// testLogger captures the messages logged through it.
+type testLogger struct {
+	mx   sync.Mutex
+	msgs []string
+}
+
+func (l *testLogger) logf(level, format string, args ...interface{}) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	l.msgs = append(l.msgs, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Debugf(format string, args ...interface{}) { l.logf("DEBUG", format, args...) }
+func (l *testLogger) Infof(format string, args ...interface{})  { l.logf("INFO", format, args...) }
+func (l *testLogger) Warnf(format string, args ...interface{})  { l.logf("WARN", format, args...) }
+func (l *testLogger) Errorf(format string, args ...interface{}) { l.logf("ERROR", format, args...) }
+
+// logged returns true if a message containing s was logged.
+func (l *testLogger) logged(s string) bool {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for _, m := range l.msgs {
+		if strings.Contains(m, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTestCrawler creates a crawler without the default pacing between dials
+// and anchors.
+func newTestCrawler(t *testing.T, h Host, d DHT, opts ...Option) *Crawler {
+	t.Helper()
+	opts = append([]Option{WithConnectJitter(0), WithAnchorInterval(time.Millisecond)}, opts...)
+	c, err := NewCrawler(context.Background(), h, d, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// crawlOnce crawls a single round, waits for the queued peers to be dialed
+// and returns the records emitted on Records. The crawler is closed.
+func crawlOnce(t *testing.T, c *Crawler) []PeerRecord {
+	t.Helper()
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.drain()
+
+	var recs []PeerRecord
+	for rec := range c.Records {
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+// sequence returns an anchor strategy generating the given anchors in turn,
+// then failing.
+func sequence(anchors ...string) AnchorStrategy {
+	var mx sync.Mutex
+	return func() (string, error) {
+		mx.Lock()
+		defer mx.Unlock()
+		if len(anchors) == 0 {
+			return "", errors.New("out of anchors")
+		}
+		a := anchors[0]
+		anchors = anchors[1:]
+		return a, nil
+	}
+}
+
+// recorded returns the numbers, up to max, of the peers in recs.
+func recorded(recs []PeerRecord, max int) []int {
+	var ns []int
+	for n := 0; n <= max; n++ {
+		for _, rec := range recs {
+			if rec.PeerInfo.ID == testPeer(n) {
+				ns = append(ns, n)
+				break
+			}
+		}
+	}
+	return ns
+}
+
+// waitFor fails the test unless cond becomes true within a few seconds.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// failures returns the records emitted on Failed by a closed crawler.
+func failures(c *Crawler) []PeerRecord {
+	var recs []PeerRecord
+	for rec := range c.Failed {
+		recs = append(recs, rec)
+	}
+	return recs
+}
+
+func TestCrawlVisitsScriptedGraph(t *testing.T) {
+	// a cyclic graph with a peer reachable only through another
+	d := newMockDHT(map[int][]int{
+		0: {1, 2},
+		1: {0, 2, 3},
+		2: {1},
+		3: {4},
+	}, 0, 1)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 5 {
+		t.Fatalf("expected 5 records, got %d", len(recs))
+	}
+	for n := 0; n < 5; n++ {
+		p := testPeer(n)
+		if !c.Seen(p) {
+			t.Errorf("peer %d not visited", n)
+		}
+		if l := d.lookups(p); l != 1 {
+			t.Errorf("peer %d looked up %d times", n, l)
+		}
+	}
+}
+
+func TestCrawlSurvivesQueryErrors(t *testing.T) {
+	d := newMockDHT(nil, 0)
+	d.query = func(ctx context.Context, op string) error {
+		if op == "GetClosestPeers" {
+			return errors.New("query timed out")
+		}
+		return nil
+	}
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+
+	waitFor(t, "three anchors", func() bool { return len(d.queried()) >= 3 })
+	select {
+	case err := <-done:
+		t.Fatalf("Crawl returned early: %v", err)
+	default:
+	}
+
+	c.Close()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected Crawl to return %v, got %v", context.Canceled, err)
+	}
+}
+
+func TestConnectSuccess(t *testing.T) {
+	h := newMockHost()
+	c := newTestCrawler(t, h, newMockDHT(nil, 0))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 || recs[0].PeerInfo.ID != testPeer(0) {
+		t.Fatalf("expected a record for peer 0, got %v", recs)
+	}
+	if fs := failures(c); len(fs) != 0 {
+		t.Errorf("expected no failures, got %d", len(fs))
+	}
+	if n := h.connects(testPeer(0)); n != 1 {
+		t.Errorf("expected 1 connect attempt, got %d", n)
+	}
+}
+
+func TestConnectFailure(t *testing.T) {
+	errDial := errors.New("dial failed")
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error { return errDial }
+	c := newTestCrawler(t, h, newMockDHT(nil, 0))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+	fs := failures(c)
+	if len(fs) != 1 || fs[0].Err != errDial || fs[0].DialBackoff {
+		t.Fatalf("expected a failure with the dial error, got %v", fs)
+	}
+	if n := h.connects(testPeer(0)); n != 1 {
+		t.Errorf("expected 1 connect attempt, got %d", n)
+	}
+}
+
+func TestDialTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+
+	// hang in Connect until the dial is given up
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	c := newTestCrawler(t, h, newMockDHT(nil, 0), WithDialTimeout(timeout))
+	defer c.Close()
+
+	start := time.Now()
+	if recs := crawlOnce(t, c); len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 20*timeout {
+		t.Errorf("expected the dial to be aborted after %s, the crawl took %s", timeout, elapsed)
+	}
+	fs := failures(c)
+	if len(fs) != 1 || fs[0].Err != context.DeadlineExceeded {
+		t.Fatalf("expected a failure with the dial timeout, got %v", fs)
+	}
+}
+
+func TestConnectDialBackoff(t *testing.T) {
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error { return swarm.ErrDialBackoff }
+	c := newTestCrawler(t, h, newMockDHT(nil, 0),
+		WithMaxDialRetries(2), WithBackoff(time.Millisecond, time.Millisecond))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+	fs := failures(c)
+	if len(fs) != 1 || fs[0].Err != swarm.ErrDialBackoff || !fs[0].DialBackoff {
+		t.Fatalf("expected a dial backoff failure, got %v", fs)
+	}
+	if n := h.connects(testPeer(0)); n != 3 {
+		t.Errorf("expected 3 connect attempts, got %d", n)
+	}
+}
+
+func TestCrawlTraversesDeepChain(t *testing.T) {
+	// a chain deep enough that a recursive traversal would nest a call per
+	// peer
+	const n = 500
+	edges := make(map[int][]int, n)
+	for i := 0; i < n-1; i++ {
+		edges[i] = []int{i + 1}
+	}
+	d := newMockDHT(edges, 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	if pc := c.PeerCount(); pc != n {
+		t.Fatalf("expected %d peers visited, got %d", n, pc)
+	}
+	for i := 0; i < n; i++ {
+		if l := d.lookups(testPeer(i)); l != 1 {
+			t.Fatalf("peer %d looked up %d times", i, l)
+		}
+	}
+}
+
+func TestAnchorInterval(t *testing.T) {
+	// crawl returns the number of anchors crawled within a window
+	crawl := func(interval time.Duration) int {
+		d := newMockDHT(nil)
+		c := newTestCrawler(t, newMockHost(), d, WithAnchorInterval(interval))
+		done := make(chan error, 1)
+		go func() { done <- c.Crawl() }()
+		time.Sleep(200 * time.Millisecond)
+		c.Close()
+		<-done
+		return len(d.queried())
+	}
+
+	if n := crawl(time.Hour); n != 1 {
+		t.Errorf("expected 1 anchor with a long interval, got %d", n)
+	}
+	if n := crawl(10 * time.Millisecond); n < 5 {
+		t.Errorf("expected at least 5 anchors with a short interval, got %d", n)
+	}
+}
+
+func TestRecordFields(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0),
+		WithAnchorStrategy(func() (string, error) { return "anchor", nil }))
+	defer c.Close()
+
+	before := time.Now()
+	recs := crawlOnce(t, c)
+	after := time.Now()
+
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	rec := recs[0]
+	if rec.PeerInfo.ID != testPeer(0) {
+		t.Errorf("expected peer 0, got %s", rec.PeerInfo.ID.Pretty())
+	}
+	if len(rec.PeerInfo.Addrs) != 1 || !rec.PeerInfo.Addrs[0].Equal(testAddr(0)) {
+		t.Errorf("expected address %s, got %v", testAddr(0), rec.PeerInfo.Addrs)
+	}
+	if rec.Anchor != "anchor" {
+		t.Errorf("expected anchor %q, got %q", "anchor", rec.Anchor)
+	}
+	if rec.DiscoveredAt.Before(before) || rec.DiscoveredAt.After(after) {
+		t.Errorf("discovery time %s outside the crawl", rec.DiscoveredAt)
+	}
+}
+
+func TestRecordAgentVersion(t *testing.T) {
+	t.Run("known", func(t *testing.T) {
+		h := newMockHost()
+		h.ps.Put(testPeer(0), "AgentVersion", "go-ipfs/0.4.18/")
+		c := newTestCrawler(t, h, newMockDHT(nil, 0))
+		defer c.Close()
+
+		recs := crawlOnce(t, c)
+		if len(recs) != 1 || recs[0].AgentVersion != "go-ipfs/0.4.18/" {
+			t.Fatalf("expected agent version go-ipfs/0.4.18/, got %v", recs)
+		}
+	})
+
+	t.Run("identify pending", func(t *testing.T) {
+		c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0))
+		defer c.Close()
+
+		recs := crawlOnce(t, c)
+		if len(recs) != 1 || recs[0].AgentVersion != "" {
+			t.Fatalf("expected no agent version, got %v", recs)
+		}
+	})
+
+	t.Run("identify completes during the wait", func(t *testing.T) {
+		h := newMockHost()
+		h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+			time.AfterFunc(50*time.Millisecond, func() {
+				h.ps.Put(pi.ID, "AgentVersion", "js-ipfs/0.33.1")
+			})
+			return nil
+		}
+		c := newTestCrawler(t, h, newMockDHT(nil, 0), WithIdentifyWait(5*time.Second))
+		defer c.Close()
+
+		recs := crawlOnce(t, c)
+		if len(recs) != 1 || recs[0].AgentVersion != "js-ipfs/0.33.1" {
+			t.Fatalf("expected agent version js-ipfs/0.33.1, got %v", recs)
+		}
+	})
+}
+
+func TestRecordProtocols(t *testing.T) {
+	h := newMockHost()
+	h.ps.AddProtocols(testPeer(0), "/ipfs/kad/1.0.0", "/ipfs/id/1.0.0", "/ipfs/bitswap/1.1.0")
+	c := newTestCrawler(t, h, newMockDHT(nil, 0))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	want := []string{"/ipfs/bitswap/1.1.0", "/ipfs/id/1.0.0", "/ipfs/kad/1.0.0"}
+	if fmt.Sprint(recs[0].Protocols) != fmt.Sprint(want) {
+		t.Errorf("expected protocols %v, got %v", want, recs[0].Protocols)
+	}
+}
+
+func TestLoggerFailedDial(t *testing.T) {
+	l := &testLogger{}
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error { return errors.New("connection refused") }
+	c := newTestCrawler(t, h, newMockDHT(nil, 0), WithLogger(l))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	want := "DEBUG FAILED to connect to " + testPeer(0).Pretty() + ": connection refused"
+	if !l.logged(want) {
+		t.Errorf("expected %q to be logged, got %q", want, l.msgs)
+	}
+	if !l.logged("INFO Finished round: 1 new peers") {
+		t.Errorf("expected the round to be logged, got %q", l.msgs)
+	}
+}
+
+// starGraph returns a graph in which peer 0 is connected to peers 1 to n.
+func starGraph(n int) map[int][]int {
+	ns := make([]int, n)
+	for i := range ns {
+		ns[i] = i + 1
+	}
+	return map[int][]int{0: ns}
+}
+
+func TestMaxPeers(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(50), 0), WithMaxPeers(10))
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected Crawl to stop cleanly, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Crawl didn't stop at the peer limit")
+	}
+	if n := c.PeerCount(); n != 10 {
+		t.Errorf("expected 10 peers, got %d", n)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	// layers 0 -> 1, 2 -> 3, 4 -> 5
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {3}, 2: {4}, 4: {5}}, 0)
+	c := newTestCrawler(t, newMockHost(), d, WithMaxDepth(1))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	for n := 0; n < 6; n++ {
+		if want := n <= 2; c.Seen(testPeer(n)) != want {
+			t.Errorf("expected peer %d visited to be %t", n, want)
+		}
+	}
+}
+
+func TestRecordsDroppedWhenUnread(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(4), 0), WithRecordsBuffer(1))
+	defer c.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.CrawlRound(context.Background())
+		c.drain()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers stalled on the unread Records channel")
+	}
+	if n := len(c.Records); n != 1 {
+		t.Errorf("expected 1 buffered record, got %d", n)
+	}
+	if n := c.Dropped(); n != 4 {
+		t.Errorf("expected 4 dropped records, got %d", n)
+	}
+}
+
+func TestSeedPeersFirst(t *testing.T) {
+	d := newMockDHT(map[int][]int{10: nil}, 0)
+	seed := pstore.PeerInfo{ID: testPeer(10), Addrs: []ma.Multiaddr{testAddr(10)}}
+	c := newTestCrawler(t, newMockHost(), d, WithSeedPeers([]pstore.PeerInfo{seed}))
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+	waitFor(t, "the anchor's peers", func() bool { return c.Seen(testPeer(0)) })
+	c.Close()
+	<-done
+
+	calls := d.history()
+	want := []string{"FindPeer " + testPeer(10).Pretty(), "GetClosestPeers", "FindPeer " + testPeer(0).Pretty()}
+	if len(calls) < len(want) || fmt.Sprint(calls[:len(want)]) != fmt.Sprint(want) {
+		t.Errorf("expected the seed to be looked up before any anchor, got %v", calls)
+	}
+}
+
+func TestSnapshotPeers(t *testing.T) {
+	d := newMockDHT(map[int][]int{0: {1, 2}, 2: {3, 0}}, 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	got := make(map[peer.ID]bool)
+	for _, p := range c.SnapshotPeers() {
+		got[p] = true
+	}
+	if len(got) != 4 {
+		t.Errorf("expected 4 peers in the snapshot, got %d", len(got))
+	}
+	for n := 0; n < 4; n++ {
+		if !got[testPeer(n)] {
+			t.Errorf("peer %d missing from the snapshot", n)
+		}
+	}
+}
+
+func TestFailedChannel(t *testing.T) {
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error { return errors.New("no route") }
+	c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+	fs := failures(c)
+	if got := recorded(fs, 2); fmt.Sprint(got) != "[0 1 2]" {
+		t.Errorf("expected all peers on Failed, got %v", got)
+	}
+	for _, rec := range fs {
+		if rec.Err == nil || rec.DiscoveredAt.IsZero() {
+			t.Errorf("expected an error and a discovery time on %v", rec)
+		}
+	}
+}
+
+func TestMaxDialRetries(t *testing.T) {
+	for _, tc := range []struct {
+		backoffs, retries int
+		connects          int
+		connected         bool
+	}{
+		{backoffs: 2, retries: 3, connects: 3, connected: true},
+		{backoffs: 3, retries: 3, connects: 4, connected: true},
+		{backoffs: 10, retries: 4, connects: 5, connected: false},
+	} {
+		h := newMockHost()
+		h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+			if h.connects(pi.ID) <= tc.backoffs {
+				return swarm.ErrDialBackoff
+			}
+			return nil
+		}
+		c := newTestCrawler(t, h, newMockDHT(nil, 0),
+			WithMaxDialRetries(tc.retries), WithBackoff(time.Millisecond, time.Millisecond))
+
+		recs := crawlOnce(t, c)
+		c.Close()
+
+		if n := h.connects(testPeer(0)); n != tc.connects {
+			t.Errorf("%d backoffs, %d retries: expected %d connects, got %d", tc.backoffs, tc.retries, tc.connects, n)
+		}
+		if connected := len(recs) == 1; connected != tc.connected {
+			t.Errorf("%d backoffs, %d retries: expected connected to be %t", tc.backoffs, tc.retries, tc.connected)
+		}
+	}
+}
+
+func TestRoundSummaries(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(2), 0),
+		WithAnchorStrategy(sequence("a", "b", "c")))
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.drain()
+
+	var sums []RoundSummary
+	for sum := range c.Rounds {
+		sums = append(sums, sum)
+	}
+	if len(sums) != 3 {
+		t.Fatalf("expected 3 round summaries, got %d", len(sums))
+	}
+	for i, want := range []RoundSummary{
+		{Anchor: "a", DHT: PrimaryDHT, NewPeers: 3, SeenPeers: 0, TotalPeers: 3},
+		{Anchor: "b", DHT: PrimaryDHT, NewPeers: 0, SeenPeers: 1, TotalPeers: 3},
+		{Anchor: "c", DHT: PrimaryDHT, NewPeers: 0, SeenPeers: 1, TotalPeers: 3},
+	} {
+		if sums[i] != want {
+			t.Errorf("expected round %d to be %+v, got %+v", i, want, sums[i])
+		}
+	}
+}
+
+func TestCrawlRoundProgress(t *testing.T) {
+	d := newMockDHT(nil)
+	d.closestTo("a", 0, 1)
+	d.closestTo("b", 2, 3)
+	d.closestTo("c", 1, 4)
+	c := newTestCrawler(t, newMockHost(), d, WithAnchorStrategy(sequence("a", "b", "c")))
+	defer c.Close()
+
+	for i, want := range []int{2, 4, 5} {
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if n := c.PeerCount(); n != want {
+			t.Errorf("expected %d peers after round %d, got %d", want, i, n)
+		}
+	}
+	if err := c.CrawlRound(context.Background()); err == nil {
+		t.Error("expected an error once the anchors ran out")
+	}
+	if keys := d.queried(); fmt.Sprint(keys) != "[a b c]" {
+		t.Errorf("expected anchors a, b and c to be queried, got %v", keys)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {2}, 2: {0, 3}}, 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	g := c.Graph()
+	for p, ns := range d.edges {
+		if fmt.Sprint(g[p]) != fmt.Sprint(ns) {
+			t.Errorf("expected %s to have edges %v, got %v", p.Pretty(), ns, g[p])
+		}
+	}
+	for p, ns := range g {
+		if _, ok := d.edges[p]; !ok && len(ns) > 0 {
+			t.Errorf("unexpected edges %v from %s", ns, p.Pretty())
+		}
+	}
+}
+
+func TestDialLatency(t *testing.T) {
+	const delay = 100 * time.Millisecond
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		time.Sleep(delay)
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(nil, 0))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	if dl := recs[0].DialLatency; dl < delay || dl > delay+50*time.Millisecond {
+		t.Errorf("expected a dial latency of about %s, got %s", delay, dl)
+	}
+}
+
+func TestDisconnectAfterDiscovery(t *testing.T) {
+	for _, disconnect := range []bool{false, true} {
+		h := newMockHost()
+		c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0), WithDisconnectAfterDiscovery(disconnect))
+
+		recs := crawlOnce(t, c)
+		c.Close()
+
+		want := 3
+		if disconnect {
+			want = 0
+		}
+		if len(recs) != 3 {
+			t.Errorf("expected 3 records, got %d", len(recs))
+		}
+		if n := len(h.net.Peers()); n != want {
+			t.Errorf("expected %d connections with disconnecting %t, got %d", want, disconnect, n)
+		}
+	}
+}
+
+func TestConnManagerTrims(t *testing.T) {
+	h := newMockHost()
+	c := newTestCrawler(t, h, newMockDHT(starGraph(10), 0), WithConnManager(2, 5, 0))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	// the first trim past the high watermark closes all but the low
+	// watermark's connections; the connection manager then holds off
+	// trimming again
+	waitFor(t, "the connections to be trimmed", func() bool { return len(h.net.Peers()) <= 11-(6-2) })
+}
+
+func TestDryRun(t *testing.T) {
+	h := newMockHost()
+	c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0), WithDryRun(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if n := h.totalConnects(); n != 0 {
+		t.Errorf("expected no connects in a dry run, got %d", n)
+	}
+	if got := recorded(recs, 2); len(got) != 3 {
+		t.Errorf("expected records for peers 0 to 2, got %v", got)
+	}
+}
+
+func TestAnchorStrategy(t *testing.T) {
+	d := newMockDHT(nil, 0)
+	c := newTestCrawler(t, newMockHost(), d, WithAnchorStrategy(sequence("x", "y", "z")))
+	defer c.Close()
+
+	if err := c.Crawl(); err == nil {
+		t.Error("expected Crawl to fail once the strategy ran out of anchors")
+	}
+	if keys := d.queried(); fmt.Sprint(keys) != "[x y z]" {
+		t.Errorf("expected anchors x, y and z to be queried, got %v", keys)
+	}
+}
+
+func TestSaturationRounds(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0), WithSaturationRounds(3))
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected Crawl to stop cleanly, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Crawl didn't stop once saturated")
+	}
+	// the round covering the graph and three finding nothing new
+	if sum := c.Summary(); sum.Rounds != 4 || sum.TotalPeers != 4 {
+		t.Errorf("expected 4 rounds and 4 peers, got %+v", sum)
+	}
+}
+
+func TestHubDialedOnce(t *testing.T) {
+	edges := starGraph(8)
+	for n := 1; n <= 8; n++ {
+		edges[n] = []int{9}
+	}
+	h := newMockHost()
+	c := newTestCrawler(t, h, newMockDHT(edges, 0, 1, 2))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	for n := 0; n <= 9; n++ {
+		if dials := h.connects(testPeer(n)); dials != 1 {
+			t.Errorf("expected peer %d to be dialed once, got %d", n, dials)
+		}
+	}
+}
+
+func TestCrawlDeadline(t *testing.T) {
+	// every query and dial hangs until its context is done
+	hang := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	d := newMockDHT(starGraph(20), 0)
+	d.query = func(ctx context.Context, op string) error {
+		if op == "GetClosestPeers" {
+			return nil
+		}
+		return hang(ctx)
+	}
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error { return hang(ctx) }
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	c, err := NewCrawler(ctx, h, d, WithConnectJitter(0), WithAnchorInterval(time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if err := c.Crawl(); err != context.DeadlineExceeded {
+		t.Errorf("expected %v, got %v", context.DeadlineExceeded, err)
+	}
+	c.Close()
+
+	if dt := time.Since(start); dt > time.Second+200*time.Millisecond {
+		t.Errorf("expected the crawl to stop at its 1s deadline, took %s", dt)
+	}
+}
+
+func TestClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	d := newMockDHT(starGraph(3), 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+	waitFor(t, "an anchor", func() bool { return len(d.queried()) > 0 })
+
+	if err := c.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+
+	// every output channel is closed once drained
+	closed := make(chan string)
+	go func() {
+		for range c.Records {
+		}
+		closed <- "Records"
+		for range c.Failed {
+		}
+		closed <- "Failed"
+		for range c.Rounds {
+		}
+		closed <- "Rounds"
+		for range c.Churn {
+		}
+		closed <- "Churn"
+		for range c.Events() {
+		}
+		closed <- "Events"
+	}()
+	for _, name := range []string{"Records", "Failed", "Rounds", "Churn", "Events"} {
+		select {
+		case got := <-closed:
+			if got != name {
+				t.Fatalf("expected %s to be closed, got %s", name, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s wasn't closed", name)
+		}
+	}
+
+	if err := c.Close(); err != nil {
+		t.Errorf("expected a second Close to succeed, got %s", err)
+	}
+	waitFor(t, "the crawler's goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+}
+
+func TestShortCrawlsDontLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 50; i++ {
+		c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0))
+		crawlOnce(t, c)
+		c.Close()
+	}
+	waitFor(t, "the crawls' goroutines to exit", func() bool { return runtime.NumGoroutine() <= before })
+
+	// nor do the rounds of a single crawl, each with a context of its own
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0))
+	defer c.Close()
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the dials", func() bool { return c.Summary().Connected == 4 })
+	during := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := c.CrawlRound(ctx)
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "the rounds' goroutines to exit", func() bool { return runtime.NumGoroutine() <= during })
+}
+
+func TestConnectWithoutConns(t *testing.T) {
+	l := &testLogger{}
+	h := newMockHost()
+	h.noConns = true
+	c := newTestCrawler(t, h, newMockDHT(nil, 0), WithLogger(l))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 0 {
+		t.Errorf("expected no records, got %d", len(recs))
+	}
+	fs := failures(c)
+	if len(fs) != 1 || fs[0].Err != ErrNoConns {
+		t.Fatalf("expected a failure with %v, got %v", ErrNoConns, fs)
+	}
+	if !l.logged("WARN Supposedly connected, but no conns to peer " + testPeer(0).Pretty()) {
+		t.Errorf("expected a warning, got %q", l.msgs)
+	}
+}
+
+func TestRoundDeltas(t *testing.T) {
+	d := newMockDHT(map[int][]int{2: {3, 0}}, 0)
+	d.closestTo("a", 0, 1)
+	d.closestTo("b", 1, 2)
+	c := newTestCrawler(t, newMockHost(), d, WithAnchorStrategy(sequence("a", "b")))
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, b := <-c.Rounds, <-c.Rounds
+	if a.NewPeers != 2 || a.SeenPeers != 0 {
+		t.Errorf("expected 2 new peers and none seen again in round a, got %+v", a)
+	}
+	// peers 2 and 3 are new; 1 and, through 2, 0 were seen in round a
+	if b.NewPeers != 2 || b.SeenPeers != 2 || b.TotalPeers != 4 {
+		t.Errorf("expected 2 new peers and 2 seen again in round b, got %+v", b)
+	}
+}
+
+func TestMultipleDHTs(t *testing.T) {
+	wan := newMockDHT(map[int][]int{0: {1}}, 0)
+	lan := newMockDHT(map[int][]int{10: {11}}, 10)
+	c := newTestCrawler(t, newMockHost(), wan, WithDHT("lan", lan))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	want := map[peer.ID]string{
+		testPeer(0):  PrimaryDHT,
+		testPeer(1):  PrimaryDHT,
+		testPeer(10): "lan",
+		testPeer(11): "lan",
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(recs))
+	}
+	for _, rec := range recs {
+		if name := want[rec.PeerInfo.ID]; rec.DHT != name {
+			t.Errorf("expected %s to be tagged %q, got %q", rec.PeerInfo.ID.Pretty(), name, rec.DHT)
+		}
+	}
+	if len(wan.queried()) != 1 || len(lan.queried()) != 1 {
+		t.Errorf("expected each DHT to be queried once, got %v and %v", wan.queried(), lan.queried())
+	}
+}
+
+func TestSaturationAcrossDHTs(t *testing.T) {
+	wan := newMockDHT(starGraph(3), 0)
+	lan := newMockDHT(nil)
+	c := newTestCrawler(t, newMockHost(), wan, WithDHT("lan", lan), WithSaturationRounds(1))
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected Crawl to stop cleanly, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Crawl didn't stop once saturated")
+	}
+	// the empty DHT doesn't make the round covering the graph idle
+	if keys := wan.queried(); len(keys) != 2 {
+		t.Errorf("expected 2 rounds to be crawled, got anchors %v", keys)
+	}
+	if sum := c.Summary(); sum.Rounds != 2 || sum.TotalPeers != 4 {
+		t.Errorf("expected 2 rounds and 4 peers, got %+v", sum)
+	}
+}
+
+func TestRoutingTableSize(t *testing.T) {
+	edges := starGraph(7)
+	edges[1] = []int{0, 2}
+	c := newTestCrawler(t, newMockHost(), newMockDHT(edges, 0))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	for _, rec := range recs {
+		want := 0
+		switch rec.PeerInfo.ID {
+		case testPeer(0):
+			want = 7
+		case testPeer(1):
+			want = 2
+		}
+		if rec.RoutingTableSize != want {
+			t.Errorf("expected %s to have a routing table of %d, got %d", rec.PeerInfo.ID.Pretty(), want, rec.RoutingTableSize)
+		}
+	}
+}
+
+func TestAnchorsPerRound(t *testing.T) {
+	const n = 3
+
+	// hold each anchor query until all of the round's are in flight
+	var mx sync.Mutex
+	inflight := 0
+	all := make(chan struct{})
+	d := newMockDHT(nil, 0)
+	d.query = func(ctx context.Context, op string) error {
+		if op != "GetClosestPeers" {
+			return nil
+		}
+		mx.Lock()
+		inflight++
+		wait := all
+		if inflight%n == 0 {
+			close(all)
+			all = make(chan struct{})
+		}
+		mx.Unlock()
+
+		select {
+		case <-wait:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithAnchorsPerRound(n),
+		WithAnchorStrategy(sequence("a", "b", "c", "d", "e", "f")), WithQueryTimeout(time.Second))
+	defer c.Close()
+
+	for round := 0; round < 2; round++ {
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatalf("expected the round's anchors to be queried concurrently: %s", err)
+		}
+		if keys := d.queried(); len(keys) != n*(round+1) {
+			t.Fatalf("expected %d anchor queries per round, got %v", n, keys)
+		}
+	}
+
+	keys := d.queried()
+	for _, round := range [][]string{keys[:n], keys[n:]} {
+		sort.Strings(round)
+	}
+	if fmt.Sprint(keys) != "[a b c d e f]" {
+		t.Errorf("expected each round to query its own anchors, got %v", keys)
+	}
+}
+
+func TestSaturationWithAnchorsPerRound(t *testing.T) {
+	// only anchor a leads to peers; the others find nothing new
+	d := newMockDHT(starGraph(3))
+	d.closestTo("a", 0)
+	anchors := make([]string, 16)
+	for i := range anchors {
+		anchors[i] = string('a' + rune(i))
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithAnchorStrategy(sequence(anchors...)),
+		WithAnchorsPerRound(4), WithSaturationRounds(2))
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() { done <- c.Crawl() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("expected Crawl to stop cleanly, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Crawl didn't stop once saturated")
+	}
+	// the round covering the graph and two finding nothing new
+	if keys := d.queried(); len(keys) != 12 {
+		t.Errorf("expected 3 rounds of 4 anchors to be crawled, got %v", keys)
+	}
+	if sum := c.Summary(); sum.Rounds != 3 || sum.TotalPeers != 4 {
+		t.Errorf("expected 3 rounds and 4 peers, got %+v", sum)
+	}
+}
+
+func TestRecordConnectedness(t *testing.T) {
+	h := newMockHost()
+	// the swarm claims it can reach peer 1 despite the connection, and the
+	// dial to peer 2 fails
+	h.net.reported = map[peer.ID]inet.Connectedness{testPeer(1): inet.CanConnect}
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		if pi.ID == testPeer(2) {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0))
+	defer c.Close()
+
+	recs := append(crawlOnce(t, c), failures(c)...)
+
+	want := map[peer.ID]inet.Connectedness{
+		testPeer(0): inet.Connected,
+		testPeer(1): inet.CanConnect,
+		testPeer(2): inet.NotConnected,
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(recs))
+	}
+	for _, rec := range recs {
+		if c := want[rec.PeerInfo.ID]; rec.Connectedness != c {
+			t.Errorf("expected %s to be %s, got %s", rec.PeerInfo.ID.Pretty(), connectednessString(c), connectednessString(rec.Connectedness))
+		}
+	}
+}
+
+func TestWorkers(t *testing.T) {
+	const n = 3
+
+	// hold the first dials until all the workers are dialing
+	release := make(chan struct{})
+	var mx sync.Mutex
+	var inflight, peak int
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		mx.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mx.Unlock()
+
+		<-release
+		time.Sleep(time.Millisecond)
+
+		mx.Lock()
+		inflight--
+		mx.Unlock()
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(20), 0), WithWorkers(n))
+	defer c.Close()
+
+	done := make(chan []PeerRecord)
+	go func() {
+		var recs []PeerRecord
+		if err := c.CrawlRound(context.Background()); err == nil {
+			c.drain()
+			for rec := range c.Records {
+				recs = append(recs, rec)
+			}
+		}
+		done <- recs
+	}()
+	waitFor(t, "the workers to dial", func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return peak == n
+	})
+	close(release)
+
+	if recs := <-done; len(recs) != 21 {
+		t.Fatalf("expected the queue to be drained into 21 records, got %d", len(recs))
+	}
+	mx.Lock()
+	defer mx.Unlock()
+	if peak != n {
+		t.Errorf("expected %d dials in flight, got %d", n, peak)
+	}
+}
+
+func TestDialPacing(t *testing.T) {
+	const n = 11
+	for _, tc := range []struct {
+		name string
+		opts []Option
+		rate float64
+	}{
+		// one dial per worker per jitter window
+		{"jitter", []Option{WithWorkers(4), WithConnectJitter(100 * time.Millisecond)}, 40},
+		{"connect rate", []Option{WithWorkers(4), WithConnectRate(50, 1)}, 50},
+	} {
+		var mx sync.Mutex
+		var dials []time.Time
+		h := newMockHost()
+		h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+			mx.Lock()
+			dials = append(dials, time.Now())
+			mx.Unlock()
+			return nil
+		}
+		c := newTestCrawler(t, h, newMockDHT(starGraph(n-1), 0), tc.opts...)
+
+		if recs := crawlOnce(t, c); len(recs) != n {
+			t.Fatalf("%s: expected %d records, got %d", tc.name, n, len(recs))
+		}
+		c.Close()
+
+		// the first dial goes through at once, the others at the rate
+		want := time.Duration(float64(n-1) / tc.rate * float64(time.Second))
+		if span := dials[n-1].Sub(dials[0]); span < want*9/10 || span > want+500*time.Millisecond {
+			t.Errorf("%s: expected %d dials to take about %s, took %s", tc.name, n, want, span)
+		}
+	}
+}
+
+func TestMaxInflightDials(t *testing.T) {
+	const max = 2
+
+	var mx sync.Mutex
+	var inflight, peak int
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		mx.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mx.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mx.Lock()
+		inflight--
+		mx.Unlock()
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(8), 0), WithWorkers(8), WithMaxInflightDials(max))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 9 {
+		t.Fatalf("expected 9 records, got %d", len(recs))
+	}
+	if peak > max {
+		t.Errorf("expected at most %d dials in flight, got %d", max, peak)
+	}
+	if peak < max {
+		t.Errorf("expected the workers to fill the %d dial slots, got %d", max, peak)
+	}
+}
+
+func TestRefreshInterval(t *testing.T) {
+	const refresh = 50 * time.Millisecond
+
+	// peer 2 is encountered through both 0 and 1
+	h := newMockHost()
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {2}}, 0)
+	c := newTestCrawler(t, h, d, WithRefreshInterval(refresh))
+	defer c.Close()
+
+	round := func(dials int) {
+		t.Helper()
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, "the dials", func() bool { return h.totalConnects() >= dials })
+	}
+	round(3)
+	round(3)
+	for n := 0; n <= 2; n++ {
+		if l, dials := d.lookups(testPeer(n)), h.connects(testPeer(n)); l != 1 || dials != 1 {
+			t.Errorf("expected peer %d not to be refreshed within the interval, got %d lookups and %d dials", n, l, dials)
+		}
+	}
+
+	time.Sleep(refresh)
+	round(6)
+	for n := 0; n <= 2; n++ {
+		if l, dials := d.lookups(testPeer(n)), h.connects(testPeer(n)); l != 2 || dials != 2 {
+			t.Errorf("expected peer %d to be refreshed once, got %d lookups and %d dials", n, l, dials)
+		}
+	}
+
+	c.drain()
+	var recs []PeerRecord
+	for rec := range c.Records {
+		recs = append(recs, rec)
+	}
+	if len(recs) != 6 {
+		t.Errorf("expected each peer to be recorded again when refreshed, got %d records", len(recs))
+	}
+}
+
+func TestBackoffHandling(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		// peer 1 is in dial backoff for its first two dials, and peer 2's
+		// dials fail outright
+		h := newMockHost()
+		h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+			switch {
+			case pi.ID == testPeer(1) && h.connects(pi.ID) <= 2:
+				return swarm.ErrDialBackoff
+			case pi.ID == testPeer(2):
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0),
+			WithBackoffHandling(enabled), WithBackoff(time.Millisecond, time.Millisecond))
+
+		recs := crawlOnce(t, c)
+		fs := failures(c)
+		c.Close()
+
+		want, connects := "[0 1]", 3
+		if !enabled {
+			want, connects = "[0]", 1
+		}
+		if got := recorded(recs, 2); fmt.Sprint(got) != want {
+			t.Errorf("backoff handling %t: expected peers %s to be recorded, got %v", enabled, want, got)
+		}
+		if n := h.connects(testPeer(1)); n != connects {
+			t.Errorf("backoff handling %t: expected %d connects to the peer in backoff, got %d", enabled, connects, n)
+		}
+		if n := h.connects(testPeer(2)); n != 1 {
+			t.Errorf("backoff handling %t: expected the failing peer to be dialed once, got %d", enabled, n)
+		}
+		for _, f := range fs {
+			if f.DialBackoff {
+				t.Errorf("backoff handling %t: expected %s not to have given up from dial backoff", enabled, f.PeerInfo.ID.Pretty())
+			}
+		}
+		if len(fs) != 3-len(recs) {
+			t.Errorf("backoff handling %t: expected %d failures, got %d", enabled, 3-len(recs), len(fs))
+		}
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	const base, max = 100 * time.Millisecond, time.Second
+
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil), WithBackoff(base, max),
+		WithRandSource(mrand.New(mrand.NewSource(1))))
+	defer c.Close()
+
+	for attempt, bound := range map[int]time.Duration{
+		1: base,
+		2: 2 * base,
+		3: 4 * base,
+		4: 8 * base,
+		5: max,
+		// the doubling stops at the cap
+		40: max,
+	} {
+		var longest time.Duration
+		for i := 0; i < 200; i++ {
+			dt := c.backoffDelay(attempt)
+			if dt < 0 || dt > bound {
+				t.Fatalf("attempt %d: expected a delay of at most %s, got %s", attempt, bound, dt)
+			}
+			if dt > longest {
+				longest = dt
+			}
+		}
+		// full jitter spreads the delays over the whole range
+		if longest < bound/2 {
+			t.Errorf("attempt %d: expected delays up to about %s, the longest was %s", attempt, bound, longest)
+		}
+	}
+}
+
+func TestWorkersExitWithRecordsFull(t *testing.T) {
+	// hold the dials until the crawl is cancelled, so that every worker has a
+	// record to emit into the full buffer
+	release := make(chan struct{})
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		<-release
+		return nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c, err := NewCrawler(ctx, h, newMockDHT(starGraph(6), 0),
+		WithConnectJitter(0), WithWorkers(4), WithRecordsBuffer(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	go c.CrawlRound(ctx)
+	waitFor(t, "the workers to be dialing", func() bool { return c.ActiveWorkers() == 4 })
+	cancel()
+	close(release)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("workers blocked on the full Records channel after cancellation")
+	}
+	if n := len(c.Records); n != 1 {
+		t.Errorf("expected 1 buffered record, got %d", n)
+	}
+	if n := c.Dropped(); n != 3 {
+		t.Errorf("expected the other 3 records to be dropped, got %d", n)
+	}
+}
+
+func TestMaxNeighborsPerPeer(t *testing.T) {
+	const max = 3
+
+	d := newMockDHT(starGraph(10), 0)
+	c := newTestCrawler(t, newMockHost(), d, WithMaxNeighborsPerPeer(max))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1+max {
+		t.Errorf("expected the hub and %d of its neighbors to be recorded, got %d records", max, len(recs))
+	}
+	looked := 0
+	for n := 1; n <= 10; n++ {
+		looked += d.lookups(testPeer(n))
+	}
+	if looked != max {
+		t.Errorf("expected %d neighbors to be looked up, got %d", max, looked)
+	}
+	// the graph keeps every edge found
+	if n := len(c.peers.neighbors(testPeer(0))); n != 10 {
+		t.Errorf("expected the graph to record 10 neighbors of the hub, got %d", n)
+	}
+}
@@ -0,0 +1,77 @@
+package crawl
+
+import peer "github.com/libp2p/go-libp2p-peer"
+
+// Event is an event of the crawl, received from Events. It is one of
+// PeerDiscovered, PeerFailed, RoundComplete, QueryError, Idle and
+// CrawlFinished.
+type Event interface {
+	event()
+}
+
+// PeerDiscovered is the event of a record being emitted on Records.
+type PeerDiscovered struct {
+	Record PeerRecord
+}
+
+// PeerFailed is the event of a record being emitted on Failed.
+type PeerFailed struct {
+	Record PeerRecord
+}
+
+// RoundComplete is the event of a summary being emitted on Rounds.
+type RoundComplete struct {
+	Summary RoundSummary
+}
+
+// QueryError is the event of a failed DHT query. Op is the DHT method, and
+// Peer the peer it was querying for, unless Op is GetClosestPeers, when Key
+// is the anchor.
+type QueryError struct {
+	Op   string
+	Peer peer.ID
+	Key  string
+	Err  error
+}
+
+// Idle is the event of the workers having dialed every queued peer.
+type Idle struct{}
+
+// CrawlFinished is the last event of the crawl, sent as the channels are
+// closed.
+type CrawlFinished struct {
+	Summary FinalSummary
+}
+
+func (PeerDiscovered) event() {}
+func (PeerFailed) event()     {}
+func (RoundComplete) event()  {}
+func (QueryError) event()     {}
+func (Idle) event()           {}
+func (CrawlFinished) event()  {}
+
+// Events returns a channel receiving every event of the crawl, in the order
+// each emitting goroutine emits them; events from different workers and
+// traversals interleave. Events are dropped if the channel, sized like
+// Records, is full. It is closed along with Records.
+func (c *Crawler) Events() <-chan Event {
+	return c.events
+}
+
+// emitEvent sends ev on the events channel without blocking.
+func (c *Crawler) emitEvent(ev Event) {
+	c.emitMx.RLock()
+	defer c.emitMx.RUnlock()
+	if c.closed {
+		return
+	}
+	c.sendEvent(ev)
+}
+
+// sendEvent is emitEvent for callers holding emitMx.
+func (c *Crawler) sendEvent(ev Event) {
+	select {
+	case c.events <- ev:
+	default:
+	}
+}
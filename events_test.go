@@ -0,0 +1,85 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// eventName describes ev by its type and the peer it concerns, if any.
+func eventName(ev Event) string {
+	switch ev := ev.(type) {
+	case PeerDiscovered:
+		return fmt.Sprintf("PeerDiscovered %v", recorded([]PeerRecord{ev.Record}, 9))
+	case PeerFailed:
+		return fmt.Sprintf("PeerFailed %v", recorded([]PeerRecord{ev.Record}, 9))
+	case RoundComplete:
+		return "RoundComplete " + ev.Summary.Anchor
+	case QueryError:
+		return "QueryError " + ev.Op + " " + ev.Key
+	default:
+		return fmt.Sprintf("%T", ev)[len("crawl."):]
+	}
+}
+
+func TestEvents(t *testing.T) {
+	// hold the dials until the round completes, and fail peer 1's
+	release := make(chan struct{})
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		<-release
+		if pi.ID == testPeer(1) {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	var mx sync.Mutex
+	failQueries := false
+	d := newMockDHT(map[int][]int{0: {1}}, 0)
+	d.query = func(ctx context.Context, op string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		if failQueries {
+			return errors.New("no peers in routing table")
+		}
+		return nil
+	}
+	c := newTestCrawler(t, h, d, WithWorkers(1), WithAnchorStrategy(sequence("a", "b")))
+	defer c.Close()
+
+	var got []string
+	next := func() string {
+		ev, ok := <-c.Events()
+		if !ok {
+			return "closed"
+		}
+		got = append(got, eventName(ev))
+		return got[len(got)-1]
+	}
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	for next() != "Idle" {
+	}
+
+	mx.Lock()
+	failQueries = true
+	mx.Unlock()
+	if err := c.CrawlRound(context.Background()); err == nil {
+		t.Fatal("expected the second round to fail")
+	}
+	c.Close()
+	for next() != "closed" {
+	}
+
+	want := "[RoundComplete a PeerDiscovered [0] PeerFailed [1] Idle QueryError GetClosestPeers b CrawlFinished]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("expected events %s, got %v", want, got)
+	}
+}
@@ -0,0 +1,147 @@
+package crawl
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// WriteDOT writes the peer graph recorded so far to w in Graphviz DOT format.
+func (c *Crawler) WriteDOT(w io.Writer) error {
+	g := c.Graph()
+
+	nodes := make(map[peer.ID]struct{})
+	for p, ns := range g {
+		nodes[p] = struct{}{}
+		for _, n := range ns {
+			nodes[n] = struct{}{}
+		}
+	}
+
+	sorted := sortedPeers(nodes)
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph crawl {")
+	for _, p := range sorted {
+		fmt.Fprintf(bw, "\t%q [label=%q];\n", p.Pretty(), shortID(p))
+	}
+	for _, p := range sorted {
+		for _, n := range g[p] {
+			fmt.Fprintf(bw, "\t%q -> %q;\n", p.Pretty(), n.Pretty())
+		}
+	}
+	fmt.Fprintln(bw, "}")
+
+	return bw.Flush()
+}
+
+// WriteGraphJSON writes the peer graph recorded so far to w as a JSON object
+// mapping each peer's base58 ID to the IDs of its neighbors. Peers are
+// written one at a time rather than copying the whole graph first, so peers
+// recorded while writing may be missed.
+func (c *Crawler) WriteGraphJSON(w io.Writer) error {
+	ps := c.peers.sources()
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("{")
+	for i, p := range ps {
+		if i > 0 {
+			bw.WriteString(",")
+		}
+		k, err := json.Marshal(p.Pretty())
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(peerStrings(c.peers.neighbors(p)))
+		if err != nil {
+			return err
+		}
+		bw.Write(k)
+		bw.WriteString(":")
+		bw.Write(v)
+	}
+	bw.WriteString("}\n")
+
+	return bw.Flush()
+}
+
+// shortID returns the last characters of p's base58 encoding, which is how
+// peers are usually abbreviated in the IPFS tooling.
+func shortID(p peer.ID) string {
+	s := p.Pretty()
+	if len(s) > 6 {
+		s = s[len(s)-6:]
+	}
+	return s
+}
+
+func sortedPeers(set map[peer.ID]struct{}) []peer.ID {
+	ps := make([]peer.ID, 0, len(set))
+	for p := range set {
+		ps = append(ps, p)
+	}
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	return ps
+}
+
+// StreamJSON consumes Records, writing each record to w as a line of JSON,
+// until ctx is done or the crawler is closed. If w has a Flush method, it is
+// flushed after every record.
+func (c *Crawler) StreamJSON(ctx context.Context, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	f, _ := w.(interface {
+		Flush() error
+	})
+
+	for {
+		select {
+		case rec, ok := <-c.Records:
+			if !ok {
+				return nil
+			}
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+			if f != nil {
+				if err := f.Flush(); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// WriteCSV writes records to w as CSV, with a header row. Each record's
+// multiaddrs are joined with spaces.
+func WriteCSV(w io.Writer, records []PeerRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"peer_id", "addrs", "agent_version", "discovered_at"}); err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		row := []string{
+			rec.PeerInfo.ID.Pretty(),
+			strings.Join(addrStrings(rec.PeerInfo.Addrs), " "),
+			rec.AgentVersion,
+			rec.DiscoveredAt.Format(time.RFC3339Nano),
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
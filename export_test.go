@@ -0,0 +1,166 @@
+package crawl
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestWriteDOT(t *testing.T) {
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {2}, 2: {0, 3}}, 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	var buf bytes.Buffer
+	if err := c.WriteDOT(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "digraph crawl {\n") || !strings.HasSuffix(out, "}\n") {
+		t.Errorf("expected a digraph, got %q", out)
+	}
+	if n := strings.Count(out, "[label="); n != 4 {
+		t.Errorf("expected 4 nodes, got %d", n)
+	}
+	if n := strings.Count(out, " -> "); n != 5 {
+		t.Errorf("expected 5 edges, got %d", n)
+	}
+	edge := "\t\"" + testPeer(2).Pretty() + "\" -> \"" + testPeer(3).Pretty() + "\";\n"
+	if !strings.Contains(out, edge) {
+		t.Errorf("expected the edge from peer 2 to 3 in %q", out)
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(2), 0),
+		WithAnchorStrategy(sequence("anchor")))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.drain()
+
+	var buf bytes.Buffer
+	if err := c.StreamJSON(context.Background(), &buf); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	s := bufio.NewScanner(&buf)
+	for s.Scan() {
+		var rec jsonRecord
+		if err := json.Unmarshal(s.Bytes(), &rec); err != nil {
+			t.Fatalf("error decoding %q: %s", s.Text(), err)
+		}
+		if rec.Anchor != "anchor" || len(rec.Addrs) != 1 || rec.Connected != "Connected" {
+			t.Errorf("unexpected record %+v", rec)
+		}
+		ids[rec.ID] = true
+	}
+	for n := 0; n < 3; n++ {
+		if !ids[testPeer(n).Pretty()] {
+			t.Errorf("peer %d missing from the output", n)
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("expected 3 records, got %d", len(ids))
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	at := time.Date(2019, 2, 1, 12, 30, 0, 0, time.UTC)
+	recs := []PeerRecord{
+		{
+			PeerInfo:     pstore.PeerInfo{ID: testPeer(0), Addrs: []ma.Multiaddr{testAddr(0), testAddr(1)}},
+			AgentVersion: "go-ipfs/0.4.18/",
+			DiscoveredAt: at,
+		},
+		{
+			PeerInfo:     pstore.PeerInfo{ID: testPeer(1)},
+			AgentVersion: "agent, with \"quotes\"",
+			DiscoveredAt: at.Add(time.Millisecond),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, recs); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{"peer_id", "addrs", "agent_version", "discovered_at"},
+		{testPeer(0).Pretty(), testAddr(0).String() + " " + testAddr(1).String(), "go-ipfs/0.4.18/", "2019-02-01T12:30:00Z"},
+		{testPeer(1).Pretty(), "", "agent, with \"quotes\"", "2019-02-01T12:30:00.001Z"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("expected %d rows, got %d", len(want), len(rows))
+	}
+	for i := range want {
+		if strings.Join(rows[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("expected row %d to be %q, got %q", i, want[i], rows[i])
+		}
+	}
+}
+
+func TestWriteGraphJSON(t *testing.T) {
+	graph := map[int][]int{0: {1, 2}, 1: {2}, 2: {0, 3}}
+	c := newTestCrawler(t, newMockHost(), newMockDHT(graph, 0))
+	defer c.Close()
+
+	var buf bytes.Buffer
+	if err := c.WriteGraphJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if out := buf.String(); out != "{}\n" {
+		t.Errorf("expected an empty graph before crawling, got %q", out)
+	}
+
+	crawlOnce(t, c)
+
+	buf.Reset()
+	if err := c.WriteGraphJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var got map[string][]string
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("error decoding the graph %q: %s", buf.String(), err)
+	}
+
+	want := make(map[string][]string)
+	for n, ns := range graph {
+		for _, m := range ns {
+			want[testPeer(n).Pretty()] = append(want[testPeer(n).Pretty()], testPeer(m).Pretty())
+		}
+	}
+	// peer 3 has no neighbors
+	if ns, ok := got[testPeer(3).Pretty()]; ok && len(ns) == 0 {
+		delete(got, testPeer(3).Pretty())
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %d peers with neighbors, got %v", len(want), got)
+	}
+	for p, ns := range want {
+		sort.Strings(ns)
+		sort.Strings(got[p])
+		if strings.Join(got[p], " ") != strings.Join(ns, " ") {
+			t.Errorf("expected %s to neighbor %v, got %v", p, ns, got[p])
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package crawl
+
+import (
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// accept reports whether a peer found in the DHT should be dialed.
+func (c *Crawler) accept(pi pstore.PeerInfo) bool {
+	if !c.dialable(pi) {
+		c.log.Debugf("Skipping peer %s with no dialable addresses", pi.ID.Pretty())
+		return false
+	}
+
+	if c.cfg.whitelist != nil {
+		if _, ok := c.cfg.whitelist[pi.ID]; !ok {
+			c.log.Debugf("Skipping peer %s not in whitelist", pi.ID.Pretty())
+			return false
+		}
+	}
+
+	for _, f := range c.cfg.filters {
+		if !f(pi) {
+			c.log.Debugf("Skipping filtered peer %s", pi.ID.Pretty())
+			return false
+		}
+	}
+
+	if n := c.dialableAddrs(pi); n < c.cfg.minAddrs {
+		c.log.Debugf("Skipping peer %s with %d dialable addresses", pi.ID.Pretty(), n)
+		return false
+	}
+
+	return true
+}
+
+// blacklisted reports whether p must be skipped entirely.
+func (c *Crawler) blacklisted(p peer.ID) bool {
+	_, ok := c.cfg.blacklist[p]
+	return ok
+}
+
+// dialable reports whether pi has any address worth dialing: any address at
+// all if private addresses are allowed, otherwise a public or DNS one, using
+// one of the transports of WithTransportFilter if set.
+func (c *Crawler) dialable(pi pstore.PeerInfo) bool {
+	return c.dialableAddrs(pi) > 0
+}
+
+// dialableAddrs returns the number of pi's addresses that dialable would
+// accept. DNS addresses count as dialable since they are only resolved when
+// the peer is dialed.
+func (c *Crawler) dialableAddrs(pi pstore.PeerInfo) int {
+	n := 0
+	for _, a := range pi.Addrs {
+		if c.cfg.transports != nil && !usesTransport(a, c.cfg.transports) {
+			continue
+		}
+		if c.cfg.privateAddrs || manet.IsPublicAddr(a) || madns.Matches(a) {
+			n++
+		}
+	}
+	return n
+}
+
+// usesTransport reports whether a uses one of protocols.
+func usesTransport(a ma.Multiaddr, protocols []int) bool {
+	for _, p := range a.Protocols() {
+		for _, code := range protocols {
+			if p.Code == code {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddressFamily restricts the IP version of the addresses dialed.
+type AddressFamily int
+
+const (
+	AddressFamilyAny AddressFamily = iota
+	AddressFamilyIPv4
+	AddressFamilyIPv6
+)
+
+// familyAddrs returns the addresses in addrs of the configured address
+// family. DNS addresses of unknown family are kept; they are filtered again
+// once resolved.
+func (c *Crawler) familyAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
+	if c.cfg.family == AddressFamilyAny {
+		return addrs
+	}
+
+	want, dns := ma.P_IP4, madns.P_DNS4
+	if c.cfg.family == AddressFamilyIPv6 {
+		want, dns = ma.P_IP6, madns.P_DNS6
+	}
+
+	var out []ma.Multiaddr
+	for _, a := range addrs {
+		switch a.Protocols()[0].Code {
+		case want, dns, madns.P_DNSADDR:
+			out = append(out, a)
+		}
+	}
+	return out
+}
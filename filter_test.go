@@ -0,0 +1,198 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestPeerFilter(t *testing.T) {
+	d := newMockDHT(starGraph(2), 0)
+	d.setAddrs(2, "/ip4/8.0.0.2/udp/4001/quic")
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithPeerFilter(func(pi pstore.PeerInfo) bool {
+		for _, a := range pi.Addrs {
+			if _, err := a.ValueForProtocol(ma.P_TCP); err == nil {
+				return true
+			}
+		}
+		return false
+	}))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 2); fmt.Sprint(got) != "[0 1]" {
+		t.Errorf("expected peers 0 and 1 to be recorded, got %v", got)
+	}
+	if n := h.connects(testPeer(2)); n != 0 {
+		t.Errorf("expected the filtered peer not to be dialed, got %d dials", n)
+	}
+}
+
+func TestSkipUndialablePeers(t *testing.T) {
+	for _, tc := range []struct {
+		private bool
+		dials   int
+	}{{false, 0}, {true, 1}} {
+		d := newMockDHT(starGraph(1), 0)
+		d.setAddrs(1, "/ip4/127.0.0.1/tcp/4001")
+		h := newMockHost()
+		c := newTestCrawler(t, h, d, WithPrivateAddrs(tc.private))
+
+		crawlOnce(t, c)
+		c.Close()
+
+		if n := h.connects(testPeer(1)); n != tc.dials {
+			t.Errorf("expected %d dials of a loopback-only peer with private addresses allowed %t, got %d",
+				tc.dials, tc.private, n)
+		}
+		if !c.Seen(testPeer(1)) {
+			t.Error("expected the loopback-only peer to be visited")
+		}
+	}
+}
+
+func TestBlacklist(t *testing.T) {
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {3}}, 0)
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithBlacklist([]peer.ID{testPeer(1)}))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[0 2]" {
+		t.Errorf("expected peers 0 and 2 to be recorded, got %v", got)
+	}
+	if n := h.connects(testPeer(1)); n != 0 {
+		t.Errorf("expected the blacklisted peer not to be dialed, got %d dials", n)
+	}
+	// the blacklisted peer isn't expanded through either
+	for _, n := range []int{1, 3} {
+		if l := d.lookups(testPeer(n)); l != 0 {
+			t.Errorf("expected peer %d not to be looked up, got %d lookups", n, l)
+		}
+	}
+}
+
+func TestWhitelist(t *testing.T) {
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {3}}, 0)
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithWhitelist([]peer.ID{testPeer(3)}))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[3]" {
+		t.Errorf("expected only peer 3 to be recorded, got %v", got)
+	}
+	if n := h.totalConnects(); n != 1 {
+		t.Errorf("expected only the whitelisted peer to be dialed, got %d dials", n)
+	}
+	// the crawl expands through the other peers to find it
+	if n := c.PeerCount(); n != 4 {
+		t.Errorf("expected 4 peers visited, got %d", n)
+	}
+}
+
+func TestTransportFilter(t *testing.T) {
+	d := newMockDHT(starGraph(3), 0)
+	d.setAddrs(1, "/ip4/8.0.0.1/tcp/4001")
+	d.setAddrs(2, "/ip4/8.0.0.2/udp/4001/quic")
+	d.setAddrs(3, "/ip4/8.0.0.3/tcp/4001", "/ip4/8.0.0.3/udp/4001/quic")
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithTransportFilter(ma.P_QUIC))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[2 3]" {
+		t.Errorf("expected the QUIC peers 2 and 3 to be recorded, got %v", got)
+	}
+	if n := h.totalConnects(); n != 2 {
+		t.Errorf("expected 2 dials, got %d", n)
+	}
+}
+
+func TestMinAddressesAfterTransportFilter(t *testing.T) {
+	d := newMockDHT(starGraph(3), 0)
+	d.setAddrs(0, "/ip4/8.0.0.0/udp/4001/quic", "/ip6/2604:1380::/udp/4001/quic")
+	// only one of the addresses uses QUIC
+	d.setAddrs(1, "/ip4/8.0.0.1/tcp/4001", "/ip4/8.0.0.1/udp/4001/quic")
+	d.setAddrs(2, "/ip4/8.0.0.2/udp/4001/quic", "/ip4/8.0.0.2/udp/4002/quic")
+	d.setAddrs(3, "/ip4/8.0.0.3/tcp/4001", "/ip4/8.0.0.3/tcp/4002")
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithTransportFilter(ma.P_QUIC), WithMinAddresses(2))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[0 2]" {
+		t.Errorf("expected the peers with 2 or more QUIC addresses to be recorded, got %v", got)
+	}
+	if n := h.totalConnects(); n != 2 {
+		t.Errorf("expected 2 dials, got %d", n)
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	const v4, v6 = "/ip4/8.0.0.1/tcp/4001", "/ip6/2604:1380::1/tcp/4001"
+	d := newMockDHT(starGraph(2), 0)
+	d.setAddrs(0, v6)
+	d.setAddrs(1, v4, v6)
+	d.setAddrs(2, v4)
+
+	var mx sync.Mutex
+	dialed := make(map[peer.ID][]string)
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		mx.Lock()
+		defer mx.Unlock()
+		dialed[pi.ID] = addrStrings(pi.Addrs)
+		return nil
+	}
+	c := newTestCrawler(t, h, d, WithAddressFamily(AddressFamilyIPv6))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 2); fmt.Sprint(got) != "[0 1]" {
+		t.Errorf("expected the peers with IPv6 addresses to be recorded, got %v", got)
+	}
+	for _, n := range []int{0, 1} {
+		if got := fmt.Sprint(dialed[testPeer(n)]); got != "["+v6+"]" {
+			t.Errorf("expected only %s to be dialed for peer %d, got %s", v6, n, got)
+		}
+	}
+	if _, ok := dialed[testPeer(2)]; ok {
+		t.Error("expected the IPv4-only peer not to be dialed")
+	}
+}
+
+func TestMinAddresses(t *testing.T) {
+	d := newMockDHT(starGraph(4), 0)
+	d.setAddrs(0, "/ip4/8.0.0.0/tcp/4001", "/ip4/8.0.0.0/udp/4001/quic")
+	d.setAddrs(2, "/ip4/8.0.0.2/tcp/4001", "/ip6/2604:1380::2/tcp/4001")
+	// a private address doesn't count towards the threshold
+	d.setAddrs(3, "/ip4/8.0.0.3/tcp/4001", "/ip4/192.168.0.3/tcp/4001")
+	d.setAddrs(4, "/ip4/8.0.0.4/tcp/4001", "/ip4/8.0.0.4/tcp/4002", "/ip4/8.0.0.4/udp/4001/quic")
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithMinAddresses(2))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 4); fmt.Sprint(got) != "[0 2 4]" {
+		t.Errorf("expected the peers with 2 or more dialable addresses to be recorded, got %v", got)
+	}
+	for _, n := range []int{1, 3} {
+		if dials := h.connects(testPeer(n)); dials != 0 {
+			t.Errorf("expected peer %d not to be dialed, got %d dials", n, dials)
+		}
+	}
+}
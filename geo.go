@@ -0,0 +1,61 @@
+package crawl
+
+import (
+	"net"
+
+	ma "github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+// GeoDB locates IP addresses for WithGeoIP. A *geoip2.Reader is adapted by
+// returning its City's Country.IsoCode and Location coordinates, which keeps
+// the GeoIP dependency out of crawlers that don't use it.
+type GeoDB interface {
+	Locate(ip net.IP) (GeoLocation, error)
+}
+
+// GeoLocation is the ISO country code and approximate coordinates of an IP
+// address.
+type GeoLocation struct {
+	Country   string
+	Latitude  float64
+	Longitude float64
+}
+
+// locate fills in the record's location from the first of the peer's public
+// addresses that resolves in the GeoIP database, if one was configured.
+func (c *Crawler) locate(rec *PeerRecord) {
+	if c.cfg.geoip == nil {
+		return
+	}
+
+	for _, a := range rec.PeerInfo.Addrs {
+		if !manet.IsPublicAddr(a) {
+			continue
+		}
+		ip := addrIP(a)
+		if ip == nil {
+			continue
+		}
+		loc, err := c.cfg.geoip.Locate(ip)
+		if err != nil {
+			c.log.Debugf("Error locating %s: %s", ip, err.Error())
+			continue
+		}
+		rec.Country = loc.Country
+		rec.Latitude = loc.Latitude
+		rec.Longitude = loc.Longitude
+		return
+	}
+}
+
+// addrIP returns the IP address a multiaddr starts with, or nil if it isn't
+// an IP multiaddr.
+func addrIP(a ma.Multiaddr) net.IP {
+	for _, code := range []int{ma.P_IP4, ma.P_IP6} {
+		if v, err := a.ValueForProtocol(code); err == nil {
+			return net.ParseIP(v)
+		}
+	}
+	return nil
+}
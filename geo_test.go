@@ -0,0 +1,41 @@
+package crawl
+
+import (
+	"errors"
+	"net"
+	"testing"
+)
+
+// geoFixture is a GeoDB locating the IP addresses it maps.
+type geoFixture map[string]GeoLocation
+
+func (f geoFixture) Locate(ip net.IP) (GeoLocation, error) {
+	loc, ok := f[ip.String()]
+	if !ok {
+		return GeoLocation{}, errors.New("address not found")
+	}
+	return loc, nil
+}
+
+func TestGeoIP(t *testing.T) {
+	db := geoFixture{"81.2.69.142": {Country: "GB", Latitude: 51.5142, Longitude: -0.0931}}
+	d := newMockDHT(starGraph(1), 0)
+	d.setAddrs(0, "/ip4/10.0.0.1/tcp/4001", "/ip4/81.2.69.142/tcp/4001")
+	c := newTestCrawler(t, newMockHost(), d, WithGeoIP(db))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+	for _, rec := range recs {
+		var want GeoLocation
+		if rec.PeerInfo.ID == testPeer(0) {
+			want = db["81.2.69.142"]
+		}
+		if got := (GeoLocation{rec.Country, rec.Latitude, rec.Longitude}); got != want {
+			t.Errorf("expected %s to be located at %+v, got %+v", rec.PeerInfo.ID.Pretty(), want, got)
+		}
+	}
+}
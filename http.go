@@ -0,0 +1,156 @@
+package crawl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync/atomic"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// Stats is a snapshot of the crawl's progress, as served on /stats.
+type Stats struct {
+	Peers         int    `json:"peers"`
+	Connects      uint64 `json:"connects"`
+	Failures      uint64 `json:"failures"`
+	Dropped       uint64 `json:"dropped"`
+	Visits        uint64 `json:"visits"`
+	Revisits      uint64 `json:"revisits"`
+	QueueDepth    int    `json:"queue_depth"`
+	ActiveWorkers int    `json:"active_workers"`
+}
+
+// Stats returns a snapshot of the crawl's progress.
+func (c *Crawler) Stats() Stats {
+	return Stats{
+		Peers:         c.peers.len(),
+		Connects:      atomic.LoadUint64(&c.connects),
+		Failures:      atomic.LoadUint64(&c.failures),
+		Dropped:       atomic.LoadUint64(&c.dropped),
+		Visits:        c.Visits(),
+		Revisits:      c.Revisits(),
+		QueueDepth:    c.QueueDepth(),
+		ActiveWorkers: c.ActiveWorkers(),
+	}
+}
+
+// QueueDepth returns the number of peers waiting to be dialed.
+func (c *Crawler) QueueDepth() int {
+	return c.work.len()
+}
+
+// Visits returns the number of new peers the traversal has visited.
+func (c *Crawler) Visits() uint64 {
+	return atomic.LoadUint64(&c.visits)
+}
+
+// Revisits returns the number of times the traversal has encountered a peer
+// it had already visited, and skipped it. A high ratio of revisits to visits
+// means the anchors are finding few new peers.
+func (c *Crawler) Revisits() uint64 {
+	return atomic.LoadUint64(&c.revisits)
+}
+
+// ActiveWorkers returns the number of workers currently connecting to a peer,
+// not counting those waiting on the connect rate limit.
+func (c *Crawler) ActiveWorkers() int {
+	return int(atomic.LoadInt64(&c.active))
+}
+
+// Handler returns an HTTP handler serving the crawl's status as JSON:
+//
+//	/stats    the crawl's Stats
+//	/peers    the visited peers, sorted, paginated with offset and limit
+//	          query parameters; empty with WithVisitedBloom
+//	/healthz  ok while the crawler is running
+//	/stream   a WebSocket receiving each connected peer's record as JSON
+func (c *Crawler) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, c.Stats())
+	})
+	mux.HandleFunc("/peers", c.servePeers)
+	mux.HandleFunc("/stream", c.serveStream)
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if c.ctx.Err() != nil {
+			http.Error(w, "crawler closed", http.StatusServiceUnavailable)
+			return
+		}
+		writeJSON(w, map[string]string{"status": "ok"})
+	})
+	return mux
+}
+
+// ServeStatus serves Handler on addr until the crawler is closed.
+func (c *Crawler) ServeStatus(addr string) error {
+	srv := &http.Server{Addr: addr, Handler: c.Handler()}
+	go func() {
+		<-c.ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+type peersPage struct {
+	Total int      `json:"total"`
+	Peers []string `json:"peers"`
+}
+
+func (c *Crawler) servePeers(w http.ResponseWriter, r *http.Request) {
+	offset, err := queryInt(r, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+	limit, err := queryInt(r, "limit", defaultPageSize)
+	if err != nil || limit <= 0 || limit > maxPageSize {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+
+	ps := c.peers.list()
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+
+	page := peersPage{Total: len(ps), Peers: []string{}}
+	if offset < len(ps) {
+		ps = ps[offset:]
+		if len(ps) > limit {
+			ps = ps[:limit]
+		}
+		page.Peers = peerStrings(ps)
+	}
+	writeJSON(w, page)
+}
+
+func queryInt(r *http.Request, key string, def int) (int, error) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+func peerStrings(ps []peer.ID) []string {
+	ss := make([]string, 0, len(ps))
+	for _, p := range ps {
+		ss = append(ss, p.Pretty())
+	}
+	return ss
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,101 @@
+package crawl
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// getJSON fetches url, checking its status, and decodes the body into v
+// unless v is nil.
+func getJSON(t *testing.T, url string, status int, v interface{}) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		t.Fatalf("expected status %d from %s, got %d", status, url, resp.StatusCode)
+	}
+	if v == nil {
+		return
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("error decoding %s: %s", url, err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(2), 0))
+	defer c.Close()
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	crawlOnce(t, c)
+
+	var stats Stats
+	getJSON(t, srv.URL+"/stats", http.StatusOK, &stats)
+	if stats.Peers != 3 || stats.Connects != 3 || stats.Visits != 3 || stats.QueueDepth != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// peers are listed in the order of their IDs
+	ps := []peer.ID{testPeer(0), testPeer(1), testPeer(2)}
+	sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+	ids := peerStrings(ps)
+
+	var page peersPage
+	getJSON(t, srv.URL+"/peers?offset=1&limit=1", http.StatusOK, &page)
+	if page.Total != 3 || len(page.Peers) != 1 || page.Peers[0] != ids[1] {
+		t.Errorf("expected the second of 3 peers, %s, got %+v", ids[1], page)
+	}
+	getJSON(t, srv.URL+"/peers?limit=0", http.StatusBadRequest, nil)
+
+	var health map[string]string
+	getJSON(t, srv.URL+"/healthz", http.StatusOK, &health)
+	if health["status"] != "ok" {
+		t.Errorf("expected an ok status, got %v", health)
+	}
+
+	c.Close()
+	getJSON(t, srv.URL+"/healthz", http.StatusServiceUnavailable, nil)
+}
+
+func TestQueueDepthAndActiveWorkers(t *testing.T) {
+	release := make(chan struct{})
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		<-release
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(6), 0), WithWorkers(2))
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() {
+		err := c.CrawlRound(context.Background())
+		c.drain()
+		errc <- err
+	}()
+
+	// both workers are stuck dialing and the queue is full behind them
+	waitFor(t, "the workers to fill up", func() bool {
+		return c.ActiveWorkers() == 2 && c.QueueDepth() == 2
+	})
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	if n, d := c.ActiveWorkers(), c.QueueDepth(); n != 0 || d != 0 {
+		t.Errorf("expected no active workers or queued peers once drained, got %d and %d", n, d)
+	}
+}
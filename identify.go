@@ -0,0 +1,128 @@
+package crawl
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	ggio "github.com/gogo/protobuf/io"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	identify "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// waitIdentify gives the identify protocol up to the configured grace period
+// to populate the peerstore with p's metadata.
+func (c *Crawler) waitIdentify(p peer.ID) {
+	if c.cfg.identifyWait <= 0 || c.agentVersion(p) != "" {
+		return
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	deadline := time.After(c.cfg.identifyWait)
+	for c.agentVersion(p) == "" {
+		select {
+		case <-ticker.C:
+		case <-deadline:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// agentVersion returns p's agent version as reported by identify, or the
+// empty string if identify has not completed.
+func (c *Crawler) agentVersion(p peer.ID) string {
+	v, err := c.h.Peerstore().Get(p, "AgentVersion")
+	if err != nil {
+		return ""
+	}
+
+	av, _ := v.(string)
+	return av
+}
+
+// protocols returns the sorted list of protocols p supports, as reported by
+// identify.
+func (c *Crawler) protocols(p peer.ID) []string {
+	protos, err := c.h.Peerstore().GetProtocols(p)
+	if err != nil || len(protos) == 0 {
+		return nil
+	}
+
+	sort.Strings(protos)
+	return protos
+}
+
+// identifyMsgSize bounds the identify message read from a peer, as in the
+// identify service.
+const identifyMsgSize = 2048
+
+// identify asks p for its identify message on a stream of its own: the
+// host's identify service merges the addresses p advertises into the
+// peerstore and keeps the address p observed us at to itself. It returns nil
+// if the host cannot open streams, WithIdentifyTimeout is zero, or p doesn't
+// answer in time.
+func (c *Crawler) identify(p peer.ID) *pb.Identify {
+	h, ok := c.h.(host.Host)
+	if !ok || c.cfg.identifyTimeout <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.identifyTimeout)
+	defer cancel()
+
+	s, err := h.NewStream(ctx, p, identify.ID)
+	if err != nil {
+		c.log.Debugf("Error opening identify stream to %s: %s", p.Pretty(), err.Error())
+		return nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		s.SetDeadline(deadline)
+	}
+
+	var mes pb.Identify
+	if err := ggio.NewDelimitedReader(s, identifyMsgSize).ReadMsg(&mes); err != nil {
+		c.log.Debugf("Error reading identify message from %s: %s", p.Pretty(), err.Error())
+		s.Reset()
+		return nil
+	}
+	go inet.FullClose(s)
+	return &mes
+}
+
+// advertisedAddrs returns the listen addresses p advertised in its identify
+// message, or nil if there is none.
+func advertisedAddrs(mes *pb.Identify) []ma.Multiaddr {
+	if mes == nil {
+		return nil
+	}
+
+	var addrs []ma.Multiaddr
+	for _, b := range mes.GetListenAddrs() {
+		if a, err := ma.NewMultiaddrBytes(b); err == nil {
+			addrs = append(addrs, a)
+		}
+	}
+	return addrs
+}
+
+// observedAddrs returns the address p reported observing our host at in its
+// identify message, or nil if there is none.
+func observedAddrs(mes *pb.Identify) []ma.Multiaddr {
+	if mes == nil || mes.GetObservedAddr() == nil {
+		return nil
+	}
+
+	a, err := ma.NewMultiaddrBytes(mes.GetObservedAddr())
+	if err != nil {
+		return nil
+	}
+	return []ma.Multiaddr{a}
+}
@@ -0,0 +1,130 @@
+package crawl
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ggio "github.com/gogo/protobuf/io"
+	inet "github.com/libp2p/go-libp2p-net"
+	identify "github.com/libp2p/go-libp2p/p2p/protocol/identify"
+	pb "github.com/libp2p/go-libp2p/p2p/protocol/identify/pb"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+func TestIdentifyAddrs(t *testing.T) {
+	advertised := []ma.Multiaddr{mustAddr("/ip4/8.1.1.1/tcp/4001"), mustAddr("/ip6/2001:db8::1/tcp/4001")}
+	observed := mustAddr("/ip4/8.2.2.2/tcp/51234")
+
+	h, target := newLoopbackHost(t), newLoopbackHost(t)
+	defer h.Close()
+	defer target.Close()
+	// answer identify with addresses other than the ones dialed
+	target.SetStreamHandler(identify.ID, func(s inet.Stream) {
+		defer inet.FullClose(s)
+		mes := pb.Identify{ObservedAddr: observed.Bytes()}
+		for _, a := range advertised {
+			mes.ListenAddrs = append(mes.ListenAddrs, a.Bytes())
+		}
+		ggio.NewDelimitedWriter(s).WriteMsg(&mes)
+	})
+
+	d := hostDHT(target)
+	c := newTestCrawler(t, h, d, WithPrivateAddrs(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	if got := fmt.Sprint(recs[0].AdvertisedAddrs); got != fmt.Sprint(advertised) {
+		t.Errorf("expected advertised addresses %v, got %s", advertised, got)
+	}
+	if got := fmt.Sprint(recs[0].ObservedAddrs); got != fmt.Sprint([]ma.Multiaddr{observed}) {
+		t.Errorf("expected observed address %s, got %s", observed, got)
+	}
+	if dialed := d.addrs[target.ID()]; fmt.Sprint(recs[0].PeerInfo.Addrs) != fmt.Sprint(dialed) {
+		t.Errorf("expected the dialed addresses %v to be kept, got %v", dialed, recs[0].PeerInfo.Addrs)
+	}
+}
+
+func TestIdentifyAbsent(t *testing.T) {
+	h, target := newLoopbackHost(t), newLoopbackHost(t)
+	defer h.Close()
+	defer target.Close()
+	target.RemoveStreamHandler(identify.ID)
+
+	c := newTestCrawler(t, h, hostDHT(target), WithPrivateAddrs(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	if recs[0].AdvertisedAddrs != nil || recs[0].ObservedAddrs != nil {
+		t.Errorf("expected no identify addresses, got %v and %v", recs[0].AdvertisedAddrs, recs[0].ObservedAddrs)
+	}
+
+	// hosts that can't open streams don't identify peers
+	c = newTestCrawler(t, newMockHost(), newMockDHT(nil, 0))
+	defer c.Close()
+	recs = crawlOnce(t, c)
+	if len(recs) != 1 || recs[0].AdvertisedAddrs != nil || recs[0].ObservedAddrs != nil {
+		t.Errorf("expected a record without identify addresses, got %v", recs)
+	}
+}
+
+func TestIdentifyTimeout(t *testing.T) {
+	h, target := newLoopbackHost(t), newLoopbackHost(t)
+	defer h.Close()
+	defer target.Close()
+	// answer the host's identify on connecting, but not the crawler's
+	var requests int32
+	stop := make(chan struct{})
+	defer close(stop)
+	target.SetStreamHandler(identify.ID, func(s inet.Stream) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			defer inet.FullClose(s)
+			ggio.NewDelimitedWriter(s).WriteMsg(&pb.Identify{})
+			return
+		}
+		defer s.Reset()
+		<-stop
+	})
+
+	c := newTestCrawler(t, h, hostDHT(target), WithPrivateAddrs(true), WithIdentifyTimeout(50*time.Millisecond))
+	defer c.Close()
+
+	start := time.Now()
+	recs := crawlOnce(t, c)
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("expected the identify request to time out after 50ms, the crawl took %s", elapsed)
+	}
+	if len(recs) != 1 || recs[0].AdvertisedAddrs != nil {
+		t.Errorf("expected a record without identify addresses, got %v", recs)
+	}
+}
+
+func TestIdentifyDisabled(t *testing.T) {
+	h, target := newLoopbackHost(t), newLoopbackHost(t)
+	defer h.Close()
+	defer target.Close()
+	target.SetStreamHandler(identify.ID, func(s inet.Stream) {
+		defer inet.FullClose(s)
+		mes := pb.Identify{ListenAddrs: [][]byte{mustAddr("/ip4/8.1.1.1/tcp/4001").Bytes()}}
+		ggio.NewDelimitedWriter(s).WriteMsg(&mes)
+	})
+
+	c := newTestCrawler(t, h, hostDHT(target), WithPrivateAddrs(true), WithIdentifyTimeout(0))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 || recs[0].AdvertisedAddrs != nil {
+		t.Errorf("expected a record without identify addresses, got %v", recs)
+	}
+}
@@ -0,0 +1,22 @@
+package crawl
+
+import "sync/atomic"
+
+// doneDialing marks a worker as finished with a peer and, if that left the
+// queue empty and all workers idle, emits an Idle event and runs the
+// WithOnIdle callback. Both fire once per idle period: queueing more work
+// re-arms them.
+func (c *Crawler) doneDialing() {
+	if atomic.AddInt64(&c.busy, -1) > 0 {
+		return
+	}
+	if c.work.len() > 0 || !atomic.CompareAndSwapInt32(&c.idleArmed, 1, 0) {
+		return
+	}
+
+	c.log.Debugf("Work queue drained; crawl idle")
+	c.emitEvent(Idle{})
+	if c.cfg.onIdle != nil {
+		c.safely("in idle callback", c.cfg.onIdle)
+	}
+}
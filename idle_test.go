@@ -0,0 +1,60 @@
+package crawl
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestOnIdle(t *testing.T) {
+	// hold the single worker on the first dial until the other peers are
+	// queued, so that the queue only empties at the end
+	release := make(chan struct{})
+	var once sync.Once
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		once.Do(func() { <-release })
+		return nil
+	}
+
+	var mx sync.Mutex
+	var idles []int
+	c := newTestCrawler(t, h, newMockDHT(starGraph(3), 0), WithAutoScale(1, 8), WithOnIdle(func() {
+		mx.Lock()
+		defer mx.Unlock()
+		idles = append(idles, h.totalConnects())
+	}))
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.CrawlRound(context.Background()) }()
+	waitFor(t, "the peers to be queued", func() bool { return c.QueueDepth() == 3 })
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor(t, "the crawl to go idle", func() bool {
+		mx.Lock()
+		defer mx.Unlock()
+		return len(idles) > 0
+	})
+	if c.QueueDepth() != 0 || h.totalConnects() != 4 {
+		t.Errorf("expected all peers to be dialed when idle, %d dialed and %d queued", h.totalConnects(), c.QueueDepth())
+	}
+
+	// another round with no new peers queues nothing, so doesn't re-arm the
+	// callback
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c.drain()
+
+	mx.Lock()
+	defer mx.Unlock()
+	if len(idles) != 1 || idles[0] != 4 {
+		t.Errorf("expected the callback to fire once after the 4 peers were dialed, got %v", idles)
+	}
+}
@@ -0,0 +1,60 @@
+package crawl
+
+import (
+	"crypto/sha256"
+	"sync"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// keySet holds the fingerprints of the public keys of the peers recorded so
+// far, for WithDedupByPubKey.
+type keySet struct {
+	mx   sync.Mutex
+	keys map[[sha256.Size]byte]peer.ID
+}
+
+func newKeySet() *keySet {
+	return &keySet{keys: make(map[[sha256.Size]byte]peer.ID)}
+}
+
+// pubKeyFingerprint returns the fingerprint of p's public key, taken from
+// the peer ID if it inlines the key or else from the peerstore. It returns
+// false if the key is unknown.
+func (c *Crawler) pubKeyFingerprint(p peer.ID) ([sha256.Size]byte, bool) {
+	k, err := p.ExtractPublicKey()
+	if err != nil || k == nil {
+		k = c.h.Peerstore().PubKey(p)
+	}
+	if k == nil {
+		return [sha256.Size]byte{}, false
+	}
+
+	b, err := k.Bytes()
+	if err != nil {
+		return [sha256.Size]byte{}, false
+	}
+	return sha256.Sum256(b), true
+}
+
+// duplicateKey reports whether a different peer with the same public key as
+// p has already been recorded, remembering p's key otherwise. Peers whose key
+// is unknown are never duplicates.
+func (c *Crawler) duplicateKey(p peer.ID) bool {
+	if c.keys == nil {
+		return false
+	}
+	fp, ok := c.pubKeyFingerprint(p)
+	if !ok {
+		return false
+	}
+
+	c.keys.mx.Lock()
+	defer c.keys.mx.Unlock()
+	if q, ok := c.keys.keys[fp]; ok && q != p {
+		c.log.Debugf("Skipping peer %s with the same public key as %s", p.Pretty(), q.Pretty())
+		return true
+	}
+	c.keys.keys[fp] = p
+	return false
+}
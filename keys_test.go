@@ -0,0 +1,86 @@
+package crawl
+
+import (
+	"crypto/rand"
+	"testing"
+
+	ic "github.com/libp2p/go-libp2p-crypto"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// keyedPeerstore hands out the public keys in keys, whether or not they
+// match the peer's ID.
+type keyedPeerstore struct {
+	pstore.Peerstore
+	keys map[peer.ID]ic.PubKey
+}
+
+func (ps *keyedPeerstore) PubKey(p peer.ID) ic.PubKey {
+	return ps.keys[p]
+}
+
+func TestDedupByPubKey(t *testing.T) {
+	shared := mustPubKey(t)
+	h := newMockHost()
+	// peer 0's key is unknown, and peers 1 and 2 are the same node under
+	// two IDs
+	h.ps = &keyedPeerstore{Peerstore: h.ps, keys: map[peer.ID]ic.PubKey{
+		testPeer(1): shared,
+		testPeer(2): shared,
+		testPeer(3): mustPubKey(t),
+	}}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(3), 0), WithDedupByPubKey(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	got := recorded(recs, 3)
+	if len(got) != 3 || got[0] != 0 || got[2] != 3 {
+		t.Errorf("expected peers 0, 3 and one of 1 and 2 to be recorded, got %v", got)
+	}
+}
+
+func TestDedupByInlinedPubKey(t *testing.T) {
+	// an ed25519 key is inlined in its peer ID
+	k := mustPubKey(t)
+	inlined, err := peer.IDFromPublicKey(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newMockHost()
+	h.ps = &keyedPeerstore{Peerstore: h.ps, keys: map[peer.ID]ic.PubKey{testPeer(0): k}}
+	d := newMockDHT(nil, 0)
+	d.roots = append(d.roots, inlined)
+	d.addrs[inlined] = d.addrs[testPeer(0)]
+	c := newTestCrawler(t, h, d, WithDedupByPubKey(true))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 1 {
+		t.Errorf("expected the peer to be recorded once, got %d records", len(recs))
+	}
+}
+
+func TestNoDedupByPubKey(t *testing.T) {
+	shared := mustPubKey(t)
+	h := newMockHost()
+	h.ps = &keyedPeerstore{Peerstore: h.ps, keys: map[peer.ID]ic.PubKey{
+		testPeer(1): shared,
+		testPeer(2): shared,
+	}}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 3 {
+		t.Errorf("expected peers to be deduplicated by ID only, got %d records", len(recs))
+	}
+}
+
+func mustPubKey(t *testing.T) ic.PubKey {
+	t.Helper()
+	_, k, err := ic.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
@@ -0,0 +1,80 @@
+package crawl
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket shared by the goroutines it paces, allowing
+// events at a steady rate with bursts of up to burst events. A limiter with
+// no rate never waits.
+type limiter struct {
+	mx    sync.Mutex
+	every time.Duration
+	burst int
+	next  time.Time
+}
+
+// newLimiter returns a limiter allowing perSecond events per second, or any
+// number if perSecond is zero.
+func newLimiter(perSecond float64, burst int) *limiter {
+	l := &limiter{burst: burst}
+	if perSecond > 0 {
+		l.every = time.Duration(float64(time.Second) / perSecond)
+	}
+	return l
+}
+
+// Wait blocks until an event is allowed or ctx is done.
+func (l *limiter) Wait(ctx context.Context) error {
+	if l.every == 0 {
+		return ctx.Err()
+	}
+
+	l.mx.Lock()
+	now := time.Now()
+	// tokens accumulate while idle, up to the burst
+	if earliest := now.Add(-time.Duration(l.burst-1) * l.every); l.next.Before(earliest) {
+		l.next = earliest
+	}
+	at := l.next
+	l.next = l.next.Add(l.every)
+	l.mx.Unlock()
+
+	d := at.Sub(now)
+	if d <= 0 {
+		return ctx.Err()
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// semaphore bounds the number of goroutines holding one of its n slots.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	return make(semaphore, n)
+}
+
+// Acquire blocks until a slot is free or ctx is done.
+func (s semaphore) Acquire(ctx context.Context) error {
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release frees a slot taken by Acquire.
+func (s semaphore) Release() {
+	<-s
+}
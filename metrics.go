@@ -0,0 +1,99 @@
+package crawl
+
+import (
+	"time"
+
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "ipfs_crawl"
+
+// metrics are always collected; they are only exported if a registerer is
+// given with WithMetrics.
+type metrics struct {
+	discovered     prometheus.Counter
+	revisited      prometheus.Counter
+	connected      prometheus.Counter
+	connectFailed  prometheus.Counter
+	backoffGiveUps prometheus.Counter
+	dropped        prometheus.Counter
+	panics         prometheus.Counter
+	queryDuration  *prometheus.HistogramVec
+	queueDepth     prometheus.GaugeFunc
+}
+
+func newMetrics(reg prometheus.Registerer, queueDepth func() float64) (*metrics, error) {
+	m := &metrics{
+		discovered: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "peers_discovered_total",
+			Help:      "Number of unique peers found in the DHT.",
+		}),
+		revisited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "peers_revisited_total",
+			Help:      "Number of times the traversal encountered an already visited peer.",
+		}),
+		connected: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connect_successes_total",
+			Help:      "Number of successful connections to discovered peers.",
+		}),
+		connectFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "connect_failures_total",
+			Help:      "Number of failed connections to discovered peers.",
+		}),
+		backoffGiveUps: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dial_backoff_giveups_total",
+			Help:      "Number of peers given up on because of dial backoff.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "records_dropped_total",
+			Help:      "Number of records dropped because the consumer fell behind.",
+		}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "panics_recovered_total",
+			Help:      "Number of panics recovered from while crawling or dialing peers.",
+		}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "query_duration_seconds",
+			Help:      "Duration of DHT queries by operation and result.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"op", "result"}),
+		queueDepth: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "work_queue_depth",
+			Help:      "Number of peers waiting to be dialed.",
+		}, queueDepth),
+	}
+
+	if reg == nil {
+		return m, nil
+	}
+
+	for _, col := range []prometheus.Collector{
+		m.discovered, m.revisited, m.connected, m.connectFailed, m.backoffGiveUps, m.dropped, m.panics,
+		m.queryDuration, m.queueDepth,
+	} {
+		if err := reg.Register(col); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// observeQuery records the duration of a DHT query started at start that
+// failed if *err is set. It is meant to be deferred.
+func (m *metrics) observeQuery(op string, start time.Time, err *error) {
+	result := "success"
+	if *err != nil {
+		result = "failure"
+	}
+	m.queryDuration.WithLabelValues(op, result).Observe(time.Since(start).Seconds())
+}
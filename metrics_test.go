@@ -0,0 +1,165 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		if pi.ID == testPeer(2) {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {0}}, 0)
+	c := newTestCrawler(t, h, d, WithMetrics(reg))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]float64)
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			switch {
+			case m.Counter != nil:
+				values[mf.GetName()] += m.GetCounter().GetValue()
+			case m.Gauge != nil:
+				values[mf.GetName()] += m.GetGauge().GetValue()
+			case m.Histogram != nil:
+				values[mf.GetName()] += float64(m.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+
+	for name, want := range map[string]float64{
+		"ipfs_crawl_peers_discovered_total":  3,
+		"ipfs_crawl_peers_revisited_total":   1,
+		"ipfs_crawl_connect_successes_total": 2,
+		"ipfs_crawl_connect_failures_total":  1,
+		"ipfs_crawl_work_queue_depth":        0,
+		// a GetClosestPeers, and a FindPeer and FindPeersConnectedToPeer
+		// for each peer
+		"ipfs_crawl_query_duration_seconds": 7,
+	} {
+		if got := values[name]; got != want {
+			t.Errorf("expected %s to be %v, got %v", name, want, got)
+		}
+	}
+}
+
+func TestQueryLatencyMetrics(t *testing.T) {
+	delays := map[string]time.Duration{
+		"GetClosestPeers":          10 * time.Millisecond,
+		"FindPeer":                 20 * time.Millisecond,
+		"FindPeersConnectedToPeer": 40 * time.Millisecond,
+	}
+	reg := prometheus.NewRegistry()
+	d := newMockDHT(map[int][]int{0: {1}}, 0)
+	// peer 1 can't be found
+	delete(d.addrs, testPeer(1))
+	d.query = func(ctx context.Context, op string) error {
+		time.Sleep(delays[op])
+		return nil
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithMetrics(reg))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	type series struct{ op, result string }
+	hists := make(map[series]*dto.Histogram)
+	for _, mf := range mfs {
+		if mf.GetName() != "ipfs_crawl_query_duration_seconds" {
+			continue
+		}
+		for _, m := range mf.GetMetric() {
+			var s series
+			for _, l := range m.GetLabel() {
+				switch l.GetName() {
+				case "op":
+					s.op = l.GetValue()
+				case "result":
+					s.result = l.GetValue()
+				}
+			}
+			hists[s] = m.GetHistogram()
+		}
+	}
+
+	for s, count := range map[series]uint64{
+		{"GetClosestPeers", "success"}:          1,
+		{"FindPeer", "success"}:                 1,
+		{"FindPeer", "failure"}:                 1,
+		{"FindPeersConnectedToPeer", "success"}: 1,
+	} {
+		h, ok := hists[s]
+		if !ok {
+			t.Errorf("no %s %s queries observed", s.result, s.op)
+			continue
+		}
+		if h.GetSampleCount() != count {
+			t.Errorf("expected %d %s %s queries, got %d", count, s.result, s.op, h.GetSampleCount())
+		}
+		// allow for scheduling delays
+		mean := time.Duration(h.GetSampleSum() / float64(h.GetSampleCount()) * float64(time.Second))
+		if want := delays[s.op]; mean < want || mean > want+100*time.Millisecond {
+			t.Errorf("expected %s %s queries to take about %s, got %s", s.result, s.op, want, mean)
+		}
+	}
+	if len(hists) != 4 {
+		t.Errorf("expected 4 query series, got %d", len(hists))
+	}
+}
+
+func TestRevisits(t *testing.T) {
+	// 1 finds 2 and 0 again, and 2 finds 0 again
+	reg := prometheus.NewRegistry()
+	d := newMockDHT(map[int][]int{0: {1, 2}, 1: {2, 0}, 2: {0}}, 0)
+	c := newTestCrawler(t, newMockHost(), d, WithMetrics(reg))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	if n := c.Visits(); n != 3 {
+		t.Errorf("expected 3 visits, got %d", n)
+	}
+	if n := c.Revisits(); n != 3 {
+		t.Errorf("expected 3 revisits, got %d", n)
+	}
+	for n := 0; n <= 2; n++ {
+		if l := d.lookups(testPeer(n)); l != 1 {
+			t.Errorf("expected peer %d to be looked up once, got %d", n, l)
+		}
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, mf := range mfs {
+		if mf.GetName() == "ipfs_crawl_peers_revisited_total" {
+			if v := mf.GetMetric()[0].GetCounter().GetValue(); v != 3 {
+				t.Errorf("expected the revisits metric to be 3, got %v", v)
+			}
+			return
+		}
+	}
+	t.Error("no revisits metric")
+}
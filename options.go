@@ -0,0 +1,928 @@
+package crawl
+
+import (
+	"database/sql"
+	"fmt"
+	mrand "math/rand"
+	"net/url"
+	"strings"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWorkers is the number of connection workers started when no
+// WithWorkers option is given.
+const DefaultWorkers = 16
+
+// DefaultExpanders is the number of peers expanded concurrently during the
+// traversal when no WithExpanders option is given.
+const DefaultExpanders = 8
+
+type config struct {
+	workers               int
+	maxWorkers            int
+	expanders             int
+	dialTimeout           time.Duration
+	queryTimeout          time.Duration
+	findPeerTimeout       time.Duration // zero uses queryTimeout
+	connectedPeersTimeout time.Duration // zero uses queryTimeout
+	queryRetries          int
+	queryRetryBase        time.Duration
+	anchorInterval        time.Duration
+	recordsBuffer         int
+	recentBuffer          int
+	connectJitter         time.Duration
+	connectRate           float64 // zero means unlimited
+	connectBurst          int
+	queryRate             float64 // zero means unlimited
+	queryBurst            int
+	maxQueries            int
+	identifyWait          time.Duration
+	identifyTimeout       time.Duration // zero skips identifying peers
+	ping                  bool
+	disconnect            bool
+	dryRun                bool
+	anchors               AnchorStrategy // nil uses random anchors
+	rand                  *lockedRand
+	anchorsPerRound       int
+	adaptiveAnchors       bool
+	xorWalkBits           int // zero draws anchors at random
+	connMgr               *connMgrConfig
+	churnWindow           time.Duration
+	geoip                 GeoDB
+	resolver              *madns.Resolver
+	logger                Logger
+	statsInterval         time.Duration
+	onIdle                func()
+	registerer            prometheus.Registerer
+	tracer                Tracer
+	maxPeers              int
+	maxDepth              int
+	maxNeighbors          int
+	saturationRounds      int
+	stateFile             string
+	previousFile          string
+	refreshInterval       time.Duration
+	bloomSize             uint
+	bloomFP               float64
+	seeds                 []pstore.PeerInfo
+	dhts                  []namedDHT
+	bootstrap             bool
+	filters               []func(pstore.PeerInfo) bool
+	transports            []int // nil dials any transport
+	score                 func(pstore.PeerInfo) int
+	blacklist             map[peer.ID]struct{}
+	dedupByKey            bool
+	whitelist             map[peer.ID]struct{}
+	sqliteDB              *sql.DB
+	sqlitePath            string
+	privateAddrs          bool
+	minAddrs              int
+	family                AddressFamily
+	dialRetries           int
+	backoffHandling       bool
+	maxDials              int
+	backoffBase           time.Duration
+	backoffMax            time.Duration
+	streamOrigins         map[string]struct{}
+}
+
+func defaults() config {
+	return config{
+		workers:         DefaultWorkers,
+		maxWorkers:      DefaultWorkers,
+		expanders:       DefaultExpanders,
+		dialTimeout:     60 * time.Second,
+		identifyTimeout: 5 * time.Second,
+		queryTimeout:    60 * time.Second,
+		anchorInterval:  5 * time.Second,
+		recordsBuffer:   256,
+		connectJitter:   2 * time.Second,
+		connectBurst:    1,
+		queryBurst:      1,
+		logger:          nopLogger{},
+		maxDepth:        -1,
+		anchorsPerRound: 1,
+		score:           addrCount,
+		resolver:        madns.DefaultResolver,
+		tracer:          nopTracer{},
+		dialRetries:     6,
+		backoffHandling: true,
+		backoffBase:     time.Second,
+		backoffMax:      30 * time.Second,
+	}
+}
+
+type connMgrConfig struct {
+	low, high int
+	grace     time.Duration
+}
+
+// dialRate returns the dials per second allowed across all workers: the
+// WithConnectRate limit if set, otherwise one dial per worker per jitter
+// window.
+func (cfg *config) dialRate() float64 {
+	if cfg.connectRate > 0 || cfg.connectJitter == 0 {
+		return cfg.connectRate
+	}
+	return float64(cfg.maxWorkers) / cfg.connectJitter.Seconds()
+}
+
+// validate checks for options that cannot be used together.
+func (cfg *config) validate() error {
+	if cfg.stateFile != "" && cfg.bloomSize > 0 {
+		return fmt.Errorf("a state file cannot be used with a bloom filter visited set")
+	}
+	if cfg.refreshInterval > 0 && cfg.bloomSize > 0 {
+		return fmt.Errorf("peers cannot be refreshed with a bloom filter visited set")
+	}
+	if cfg.adaptiveAnchors && cfg.anchors != nil {
+		return fmt.Errorf("adaptive anchors cannot be used with an anchor strategy")
+	}
+	if cfg.xorWalkBits > 0 && (cfg.anchors != nil || cfg.adaptiveAnchors) {
+		return fmt.Errorf("an XOR walk cannot be used with an anchor strategy or adaptive anchors")
+	}
+	if cfg.previousFile != "" && cfg.bloomSize > 0 {
+		return fmt.Errorf("a previous crawl cannot be compared with a bloom filter visited set")
+	}
+	return nil
+}
+
+// Option configures a Crawler.
+type Option func(*config) error
+
+// WithWorkers sets the number of workers dialing discovered peers. The
+// work queue is sized to match.
+func WithWorkers(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid number of workers: %d", n)
+		}
+		cfg.workers = n
+		cfg.maxWorkers = n
+		return nil
+	}
+}
+
+// WithExpanders sets the number of peers whose neighbors are looked up
+// concurrently while traversing the peer graph.
+func WithExpanders(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid number of expanders: %d", n)
+		}
+		cfg.expanders = n
+		return nil
+	}
+}
+
+// WithRecordsBuffer sets the buffer size of the Records and Failed channels.
+func WithRecordsBuffer(n int) Option {
+	return func(cfg *config) error {
+		if n < 0 {
+			return fmt.Errorf("invalid buffer size: %d", n)
+		}
+		cfg.recordsBuffer = n
+		return nil
+	}
+}
+
+// WithRecentBuffer keeps the last n connected peer records in memory for
+// Recent.
+func WithRecentBuffer(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid recent buffer size: %d", n)
+		}
+		cfg.recentBuffer = n
+		return nil
+	}
+}
+
+// WithDialTimeout sets the timeout for connecting to a discovered peer.
+func WithDialTimeout(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid dial timeout: %s", d)
+		}
+		cfg.dialTimeout = d
+		return nil
+	}
+}
+
+// WithQueryTimeout sets the timeout for each DHT query, unless overridden
+// for FindPeer or FindPeersConnectedToPeer queries.
+func WithQueryTimeout(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid query timeout: %s", d)
+		}
+		cfg.queryTimeout = d
+		return nil
+	}
+}
+
+// WithFindPeerTimeout sets the timeout for the FindPeer lookup of each
+// peer, overriding WithQueryTimeout.
+func WithFindPeerTimeout(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid FindPeer timeout: %s", d)
+		}
+		cfg.findPeerTimeout = d
+		return nil
+	}
+}
+
+// WithConnectedPeersTimeout sets the timeout for the
+// FindPeersConnectedToPeer lookup of each peer's neighbors, overriding
+// WithQueryTimeout.
+func WithConnectedPeersTimeout(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid FindPeersConnectedToPeer timeout: %s", d)
+		}
+		cfg.connectedPeersTimeout = d
+		return nil
+	}
+}
+
+// WithQueryRetries retries a failed query for the peers closest to an
+// anchor up to n times, waiting base before the first retry and doubling the
+// wait after each, before moving on to the next anchor.
+func WithQueryRetries(n int, base time.Duration) Option {
+	return func(cfg *config) error {
+		if n < 0 || base <= 0 {
+			return fmt.Errorf("invalid query retries: %d/%s", n, base)
+		}
+		cfg.queryRetries = n
+		cfg.queryRetryBase = base
+		return nil
+	}
+}
+
+// WithAnchorInterval sets how long the crawl waits between anchors.
+func WithAnchorInterval(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d < 0 {
+			return fmt.Errorf("invalid anchor interval: %s", d)
+		}
+		cfg.anchorInterval = d
+		return nil
+	}
+}
+
+// WithConnectJitter sets the window over which the workers' dials are
+// spread: unless WithConnectRate is given, dials across all workers are paced
+// by a shared limiter at one dial per worker per window. It defaults to 2s;
+// zero disables the pacing.
+func WithConnectJitter(max time.Duration) Option {
+	return func(cfg *config) error {
+		if max < 0 {
+			return fmt.Errorf("invalid connect jitter: %s", max)
+		}
+		cfg.connectJitter = max
+		return nil
+	}
+}
+
+// WithConnectRate limits dials across all workers to perSecond per second,
+// in bursts of up to burst dials, overriding the pacing set by
+// WithConnectJitter.
+func WithConnectRate(perSecond float64, burst int) Option {
+	return func(cfg *config) error {
+		if perSecond <= 0 || burst < 1 {
+			return fmt.Errorf("invalid connect rate: %v/%d", perSecond, burst)
+		}
+		cfg.connectRate = perSecond
+		cfg.connectBurst = burst
+		return nil
+	}
+}
+
+// WithIdentifyWait sets how long to wait after connecting for the identify
+// protocol to report the peer's metadata. By default the crawler does not
+// wait, and records whatever the peerstore holds at the time.
+func WithIdentifyWait(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d < 0 {
+			return fmt.Errorf("invalid identify wait: %s", d)
+		}
+		cfg.identifyWait = d
+		return nil
+	}
+}
+
+// WithIdentifyTimeout sets how long to wait for a connected peer to answer
+// the identify request recording the addresses it advertises and observes us
+// at. It defaults to 5 seconds; zero skips the request.
+func WithIdentifyTimeout(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d < 0 {
+			return fmt.Errorf("invalid identify timeout: %s", d)
+		}
+		cfg.identifyTimeout = d
+		return nil
+	}
+}
+
+// WithStatsInterval logs a summary of the crawl's progress at info level
+// every d.
+func WithStatsInterval(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid stats interval: %s", d)
+		}
+		cfg.statsInterval = d
+		return nil
+	}
+}
+
+// WithOnIdle sets a function called when the workers finish dialing every
+// queued peer, once each time the queue drains. It is called from a worker,
+// which is blocked until it returns.
+func WithOnIdle(f func()) Option {
+	return func(cfg *config) error {
+		if f == nil {
+			return fmt.Errorf("invalid idle callback: nil")
+		}
+		cfg.onIdle = f
+		return nil
+	}
+}
+
+// WithLogger sets the logger the crawler reports its progress to. By default
+// nothing is logged.
+func WithLogger(l Logger) Option {
+	return func(cfg *config) error {
+		if l == nil {
+			return fmt.Errorf("nil logger")
+		}
+		cfg.logger = l
+		return nil
+	}
+}
+
+// WithMetrics registers the crawler's Prometheus metrics with reg.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(cfg *config) error {
+		cfg.registerer = reg
+		return nil
+	}
+}
+
+// WithMaxPeers stops the crawl once n unique peers have been found. The
+// workers finish dialing the peers already queued, after which Records is
+// closed.
+func WithMaxPeers(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid max peers: %d", n)
+		}
+		cfg.maxPeers = n
+		return nil
+	}
+}
+
+// WithMaxDepth bounds how far the crawl expands from each anchor: at depth 0
+// only the peers closest to the anchor are crawled, at depth 1 their
+// neighbors too, and so on. By default expansion is unbounded.
+func WithMaxDepth(d int) Option {
+	return func(cfg *config) error {
+		if d < 0 {
+			return fmt.Errorf("invalid max depth: %d", d)
+		}
+		cfg.maxDepth = d
+		return nil
+	}
+}
+
+// WithMaxNeighborsPerPeer caps the number of each peer's neighbors that the
+// traversal expands into, sampling them at random, to bound the fan-out from
+// hub peers. The graph and records still reflect every neighbor.
+func WithMaxNeighborsPerPeer(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid max neighbors per peer: %d", n)
+		}
+		cfg.maxNeighbors = n
+		return nil
+	}
+}
+
+// WithStateFile persists the set of visited peers to path, snapshotting it
+// periodically and when the crawl stops. If the file exists when the crawler
+// is created, the peers it lists are not crawled again.
+func WithStateFile(path string) Option {
+	return func(cfg *config) error {
+		if path == "" {
+			return fmt.Errorf("empty state file path")
+		}
+		cfg.stateFile = path
+		return nil
+	}
+}
+
+// WithPreviousCrawl loads the peers visited by a previous crawl from the
+// state file at path, as written by WithStateFile, for ChurnSummary to
+// compare against. Unlike WithStateFile, the peers are crawled again. A
+// missing file is treated as an empty previous crawl.
+func WithPreviousCrawl(path string) Option {
+	return func(cfg *config) error {
+		if path == "" {
+			return fmt.Errorf("invalid previous crawl path: %q", path)
+		}
+		cfg.previousFile = path
+		return nil
+	}
+}
+
+// WithRefreshInterval looks visited peers up and dials them again when the
+// crawl encounters them more than d after it last did, refreshing their
+// records. Each peer is refreshed at most once every d, however often it is
+// encountered.
+func WithRefreshInterval(d time.Duration) Option {
+	return func(cfg *config) error {
+		if d <= 0 {
+			return fmt.Errorf("invalid refresh interval: %s", d)
+		}
+		cfg.refreshInterval = d
+		return nil
+	}
+}
+
+// WithVisitedBloom tracks visited peers in a bloom filter sized for n peers at
+// false positive rate fp, bounding the memory used by long crawls. The filter
+// never forgets a peer, but false positives cause some peers to be skipped,
+// and the visited peers cannot be listed or persisted.
+func WithVisitedBloom(n uint, fp float64) Option {
+	return func(cfg *config) error {
+		if n == 0 || fp <= 0 || fp >= 1 {
+			return fmt.Errorf("invalid bloom filter parameters: %d/%f", n, fp)
+		}
+		cfg.bloomSize = n
+		cfg.bloomFP = fp
+		return nil
+	}
+}
+
+// WithQueryRate limits the DHT queries issued by the crawl to perSecond per
+// second, in bursts of up to burst queries; by default queries are not rate
+// limited.
+func WithQueryRate(perSecond float64, burst int) Option {
+	return func(cfg *config) error {
+		if perSecond <= 0 || burst < 1 {
+			return fmt.Errorf("invalid query rate: %v/%d", perSecond, burst)
+		}
+		cfg.queryRate = perSecond
+		cfg.queryBurst = burst
+		return nil
+	}
+}
+
+// WithDHT adds a DHT to crawl alongside the one passed to NewCrawler, such as
+// the LAN DHT of a dual DHT setup. Each anchor is crawled in every DHT, and
+// peers are tagged with the name of the DHT they were first found in.
+func WithDHT(name string, d DHT) Option {
+	return func(cfg *config) error {
+		if name == "" || name == PrimaryDHT || d == nil {
+			return fmt.Errorf("invalid DHT %q", name)
+		}
+		for _, nd := range cfg.dhts {
+			if nd.name == name {
+				return fmt.Errorf("duplicate DHT %q", name)
+			}
+		}
+		cfg.dhts = append(cfg.dhts, namedDHT{name, d})
+		return nil
+	}
+}
+
+// WithMaxConcurrentQueries caps the number of DHT queries in flight at once,
+// independently of the number of workers and expanders. It is unlimited by
+// default.
+func WithMaxConcurrentQueries(n int) Option {
+	return func(cfg *config) error {
+		if n <= 0 {
+			return fmt.Errorf("invalid max concurrent queries: %d", n)
+		}
+		cfg.maxQueries = n
+		return nil
+	}
+}
+
+// WithBootstrap makes Validate bootstrap the DHTs before checking that the
+// host has peers.
+func WithBootstrap(bootstrap bool) Option {
+	return func(cfg *config) error {
+		cfg.bootstrap = bootstrap
+		return nil
+	}
+}
+
+// WithSeedPeers sets peers to crawl from before the first anchor, so that the
+// crawl does not depend on a populated routing table to get started.
+func WithSeedPeers(peers []pstore.PeerInfo) Option {
+	return func(cfg *config) error {
+		cfg.seeds = append(cfg.seeds, peers...)
+		return nil
+	}
+}
+
+// WithPeerScore sets the function ranking queued peers: workers dial the
+// highest scoring peer waiting. By default peers are scored by the number of
+// addresses they advertise.
+func WithPeerScore(f func(pstore.PeerInfo) int) Option {
+	return func(cfg *config) error {
+		if f == nil {
+			return fmt.Errorf("invalid peer score: nil")
+		}
+		cfg.score = f
+		return nil
+	}
+}
+
+// WithDedupByPubKey records only the first of several peer IDs backed by
+// the same public key, such as the identity and hashed encodings of one
+// Ed25519 key. Peers whose key is unknown are deduplicated by peer ID alone.
+func WithDedupByPubKey(dedup bool) Option {
+	return func(cfg *config) error {
+		cfg.dedupByKey = dedup
+		return nil
+	}
+}
+
+// WithPeerFilter adds a filter deciding which peers to dial. Peers rejected
+// by any filter are neither dialed nor recorded, but the crawl still expands
+// through them. Filters are called concurrently from multiple goroutines.
+func WithPeerFilter(f func(pstore.PeerInfo) bool) Option {
+	return func(cfg *config) error {
+		if f == nil {
+			return fmt.Errorf("invalid peer filter: nil")
+		}
+		cfg.filters = append(cfg.filters, f)
+		return nil
+	}
+}
+
+// WithTransportFilter only dials peers advertising at least one address
+// using one of the given multiaddr protocols, such as ma.P_TCP or ma.P_QUIC.
+// Other addresses don't count towards WithMinAddresses. It may be given more
+// than once.
+func WithTransportFilter(protocols ...int) Option {
+	return func(cfg *config) error {
+		if len(protocols) == 0 {
+			return fmt.Errorf("invalid transport filter: no protocols")
+		}
+		cfg.transports = append(cfg.transports, protocols...)
+		return nil
+	}
+}
+
+// WithBlacklist skips the given peers entirely: they are neither dialed nor
+// expanded through. It may be given more than once.
+func WithBlacklist(ids []peer.ID) Option {
+	return func(cfg *config) error {
+		if cfg.blacklist == nil {
+			cfg.blacklist = make(map[peer.ID]struct{}, len(ids))
+		}
+		for _, p := range ids {
+			cfg.blacklist[p] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithWhitelist restricts dialing to the given peers. The crawl still expands
+// through other peers to find them. It may be given more than once.
+func WithWhitelist(ids []peer.ID) Option {
+	return func(cfg *config) error {
+		if len(ids) == 0 {
+			return fmt.Errorf("invalid whitelist: no peers")
+		}
+		if cfg.whitelist == nil {
+			cfg.whitelist = make(map[peer.ID]struct{}, len(ids))
+		}
+		for _, p := range ids {
+			cfg.whitelist[p] = struct{}{}
+		}
+		return nil
+	}
+}
+
+// WithAddressFamily only dials addresses of the given family, skipping
+// peers with none. Records list only the addresses of that family.
+func WithAddressFamily(family AddressFamily) Option {
+	return func(cfg *config) error {
+		switch family {
+		case AddressFamilyAny, AddressFamilyIPv4, AddressFamilyIPv6:
+		default:
+			return fmt.Errorf("invalid address family: %d", family)
+		}
+		cfg.family = family
+		return nil
+	}
+}
+
+// WithPrivateAddrs allows dialing peers that only advertise private or
+// loopback addresses; by default such peers are skipped.
+func WithPrivateAddrs(allow bool) Option {
+	return func(cfg *config) error {
+		cfg.privateAddrs = allow
+		return nil
+	}
+}
+
+// WithMinAddresses skips dialing peers with fewer than n dialable addresses,
+// which are often stale. Addresses are counted after WithAddressFamily and
+// WithTransportFilter have been applied, and private addresses only count
+// with WithPrivateAddrs.
+func WithMinAddresses(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid min addresses: %d", n)
+		}
+		cfg.minAddrs = n
+		return nil
+	}
+}
+
+// WithMaxInflightDials caps the number of peers being dialed at once,
+// including peers waiting out dial backoff between attempts. It is only
+// bounded by the number of workers by default.
+func WithMaxInflightDials(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid max inflight dials: %d", n)
+		}
+		cfg.maxDials = n
+		return nil
+	}
+}
+
+// WithMaxDialRetries sets how many times a dial is retried while the peer is
+// in dial backoff before giving up on it.
+func WithMaxDialRetries(n int) Option {
+	return func(cfg *config) error {
+		if n < 0 {
+			return fmt.Errorf("invalid max dial retries: %d", n)
+		}
+		cfg.dialRetries = n
+		return nil
+	}
+}
+
+// WithBackoffHandling sets whether dials failing because the swarm is backing
+// off dialing the peer are retried. It is enabled by default; disable it for
+// hosts that aren't backed by a swarm, or to record such peers as failed
+// straight away.
+func WithBackoffHandling(enable bool) Option {
+	return func(cfg *config) error {
+		cfg.backoffHandling = enable
+		return nil
+	}
+}
+
+// WithBackoff sets the delay before retrying a dial in backoff: a random
+// duration of up to base doubled for each previous retry, capped at max. It
+// defaults to 1s and 30s.
+func WithBackoff(base, max time.Duration) Option {
+	return func(cfg *config) error {
+		if base <= 0 || max < base {
+			return fmt.Errorf("invalid dial backoff: %s/%s", base, max)
+		}
+		cfg.backoffBase = base
+		cfg.backoffMax = max
+		return nil
+	}
+}
+
+// WithSQLiteSink writes every connected peer to the peers table of the SQLite
+// database db, creating the table if needed. Rows are keyed by peer ID, and
+// peers seen again have their last_seen time updated. The caller opens db
+// with the SQLite driver of its choice and closes it after the crawler.
+func WithSQLiteSink(db *sql.DB) Option {
+	return func(cfg *config) error {
+		if db == nil {
+			return fmt.Errorf("nil SQLite database")
+		}
+		cfg.sqliteDB = db
+		cfg.sqlitePath = ""
+		return nil
+	}
+}
+
+// WithSQLitePath is WithSQLiteSink for a database the crawler opens at path
+// and closes with the crawler. It is opened with the database/sql driver
+// registered as sqlite3, which the program must import, such as
+// github.com/mattn/go-sqlite3.
+func WithSQLitePath(path string) Option {
+	return func(cfg *config) error {
+		if path == "" {
+			return fmt.Errorf("empty SQLite database path")
+		}
+		cfg.sqlitePath = path
+		cfg.sqliteDB = nil
+		return nil
+	}
+}
+
+// WithPing pings each connected peer and records the median round-trip time.
+// Pinging requires the crawler's host to be a full host.Host.
+func WithPing(enable bool) Option {
+	return func(cfg *config) error {
+		cfg.ping = enable
+		return nil
+	}
+}
+
+// WithDisconnectAfterDiscovery closes the connection to each peer once it has
+// been recorded, bounding the number of connections held open by long
+// crawls. By default connections are left open.
+func WithDisconnectAfterDiscovery(enable bool) Option {
+	return func(cfg *config) error {
+		cfg.disconnect = enable
+		return nil
+	}
+}
+
+// WithConnManager attaches a connection manager to the host's network that
+// trims connections back to low once there are more than high, sparing
+// connections younger than grace. Connections opened by the crawl are tagged
+// with CrawlTagWeight so that they are trimmed first.
+func WithConnManager(low, high int, grace time.Duration) Option {
+	return func(cfg *config) error {
+		if low < 0 || high < low || grace < 0 {
+			return fmt.Errorf("invalid connection manager limits: %d/%d/%s", low, high, grace)
+		}
+		cfg.connMgr = &connMgrConfig{low: low, high: high, grace: grace}
+		return nil
+	}
+}
+
+// WithDryRun records the peers found in the DHT without connecting to them.
+func WithDryRun(enable bool) Option {
+	return func(cfg *config) error {
+		cfg.dryRun = enable
+		return nil
+	}
+}
+
+// WithAnchorsPerRound sets the number of anchors crawled concurrently in
+// each round. It defaults to one.
+func WithAnchorsPerRound(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid anchors per round: %d", n)
+		}
+		cfg.anchorsPerRound = n
+		return nil
+	}
+}
+
+// WithRandSource draws random anchors, including the candidates of
+// WithXORWalk, and dial backoff from r instead of the system's entropy source
+// and the global math/rand source, for reproducible crawls. An anchor
+// strategy set with WithAnchorStrategy takes precedence.
+func WithRandSource(r *mrand.Rand) Option {
+	return func(cfg *config) error {
+		if r == nil {
+			return fmt.Errorf("invalid random source: nil")
+		}
+		cfg.rand = &lockedRand{r: r}
+		return nil
+	}
+}
+
+// WithAnchorStrategy sets how the anchor of each crawl round is generated.
+// An error from the strategy stops the crawl.
+func WithAnchorStrategy(s AnchorStrategy) Option {
+	return func(cfg *config) error {
+		if s == nil {
+			return fmt.Errorf("nil anchor strategy")
+		}
+		cfg.anchors = s
+		return nil
+	}
+}
+
+// WithAdaptiveAnchors biases random anchors toward regions of the keyspace
+// where the crawl has discovered fewer peers. Anchors stay uniformly random
+// until enough peers have been discovered. It cannot be used with
+// WithAnchorStrategy.
+func WithAdaptiveAnchors(enable bool) Option {
+	return func(cfg *config) error {
+		cfg.adaptiveAnchors = enable
+		return nil
+	}
+}
+
+// WithXORWalk walks the keyspace as XORWalkStrategy(bits) does, drawing
+// candidate anchors from the source given with WithRandSource if any. It
+// cannot be used with WithAnchorStrategy or WithAdaptiveAnchors.
+func WithXORWalk(bits int) Option {
+	return func(cfg *config) error {
+		if bits < 1 || bits > 16 {
+			return fmt.Errorf("invalid XOR walk prefix length: %d", bits)
+		}
+		cfg.xorWalkBits = bits
+		return nil
+	}
+}
+
+// WithSaturationRounds stops the crawl once n consecutive rounds have found
+// no new peers. Rounds whose anchor query failed are not counted.
+func WithSaturationRounds(n int) Option {
+	return func(cfg *config) error {
+		if n < 1 {
+			return fmt.Errorf("invalid saturation rounds: %d", n)
+		}
+		cfg.saturationRounds = n
+		return nil
+	}
+}
+
+// WithAutoScale varies the number of workers between min and max with the
+// depth of the work queue, overriding WithWorkers.
+func WithAutoScale(min, max int) Option {
+	return func(cfg *config) error {
+		if min < 1 || max < min {
+			return fmt.Errorf("invalid worker bounds: %d/%d", min, max)
+		}
+		cfg.workers = min
+		cfg.maxWorkers = max
+		return nil
+	}
+}
+
+// WithChurnTracking watches the host's network for crawled peers that
+// disconnect within window of the crawl connecting to them, reporting them
+// on the Churn channel.
+func WithChurnTracking(window time.Duration) Option {
+	return func(cfg *config) error {
+		if window <= 0 {
+			return fmt.Errorf("invalid churn window: %s", window)
+		}
+		cfg.churnWindow = window
+		return nil
+	}
+}
+
+// WithGeoIP annotates records with the country and approximate coordinates
+// of each peer's first public address, looked up in db.
+func WithGeoIP(db GeoDB) Option {
+	return func(cfg *config) error {
+		if db == nil {
+			return fmt.Errorf("invalid GeoIP database: nil")
+		}
+		cfg.geoip = db
+		return nil
+	}
+}
+
+// WithResolver sets the resolver used to expand DNS multiaddrs before
+// dialing. It defaults to madns.DefaultResolver.
+func WithResolver(r *madns.Resolver) Option {
+	return func(cfg *config) error {
+		if r == nil {
+			return fmt.Errorf("invalid resolver: nil")
+		}
+		cfg.resolver = r
+		return nil
+	}
+}
+
+// WithTracer traces the lookup and dialing of each peer with spans from t.
+// Tracing is disabled by default.
+func WithTracer(t Tracer) Option {
+	return func(cfg *config) error {
+		if t == nil {
+			return fmt.Errorf("invalid tracer: nil")
+		}
+		cfg.tracer = t
+		return nil
+	}
+}
+
+// WithStreamOrigins lets browser pages served from origins, such as
+// "https://dashboard.example.com", open the /stream WebSocket. Only pages
+// served by the Handler's own host can by default.
+func WithStreamOrigins(origins ...string) Option {
+	return func(cfg *config) error {
+		for _, o := range origins {
+			u, err := url.Parse(o)
+			if err != nil || u.Scheme == "" || u.Host == "" {
+				return fmt.Errorf("invalid stream origin: %q", o)
+			}
+			if cfg.streamOrigins == nil {
+				cfg.streamOrigins = make(map[string]struct{})
+			}
+			cfg.streamOrigins[strings.ToLower(o)] = struct{}{}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,65 @@
+package crawl
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDefaults(t *testing.T) {
+	cfg := defaults()
+
+	if cfg.workers != DefaultWorkers || cfg.maxWorkers != DefaultWorkers {
+		t.Errorf("expected %d workers, got %d/%d", DefaultWorkers, cfg.workers, cfg.maxWorkers)
+	}
+	if cfg.dialTimeout != 60*time.Second {
+		t.Errorf("expected a 60s dial timeout, got %s", cfg.dialTimeout)
+	}
+	if cfg.anchorInterval != 5*time.Second {
+		t.Errorf("expected a 5s anchor interval, got %s", cfg.anchorInterval)
+	}
+	if cfg.recordsBuffer != 256 {
+		t.Errorf("expected a records buffer of 256, got %d", cfg.recordsBuffer)
+	}
+	if err := cfg.validate(); err != nil {
+		t.Errorf("expected the defaults to be valid, got %s", err)
+	}
+}
+
+func TestOptionsOverrideDefaults(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil),
+		WithWorkers(3),
+		WithDialTimeout(time.Second),
+		WithAnchorInterval(time.Minute),
+		WithRecordsBuffer(7),
+	)
+	defer c.Close()
+
+	if c.cfg.workers != 3 || c.cfg.maxWorkers != 3 {
+		t.Errorf("expected 3 workers, got %d/%d", c.cfg.workers, c.cfg.maxWorkers)
+	}
+	if c.cfg.dialTimeout != time.Second {
+		t.Errorf("expected a 1s dial timeout, got %s", c.cfg.dialTimeout)
+	}
+	if c.cfg.anchorInterval != time.Minute {
+		t.Errorf("expected a 1m anchor interval, got %s", c.cfg.anchorInterval)
+	}
+	if cap(c.Records) != 7 || cap(c.Failed) != 7 {
+		t.Errorf("expected buffers of 7, got %d/%d", cap(c.Records), cap(c.Failed))
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"workers":         WithWorkers(0),
+		"expanders":       WithExpanders(0),
+		"records buffer":  WithRecordsBuffer(-1),
+		"dial timeout":    WithDialTimeout(0),
+		"query timeout":   WithQueryTimeout(-time.Second),
+		"anchor interval": WithAnchorInterval(-time.Second),
+	} {
+		cfg := defaults()
+		if err := opt(&cfg); err == nil {
+			t.Errorf("expected an error for an invalid %s", name)
+		}
+	}
+}
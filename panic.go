@@ -0,0 +1,17 @@
+package crawl
+
+import "runtime/debug"
+
+// safely runs f, recovering from any panic in it so that a single bad peer,
+// or a panicking filter or sink, doesn't bring the crawl down.
+func (c *Crawler) safely(what string, f func()) {
+	defer c.recoverPanic(what)
+	f()
+}
+
+func (c *Crawler) recoverPanic(what string) {
+	if r := recover(); r != nil {
+		c.metrics.panics.Inc()
+		c.log.Errorf("Recovered from panic %s: %v\n%s", what, r, debug.Stack())
+	}
+}
@@ -0,0 +1,45 @@
+package crawl
+
+import (
+	"fmt"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFilterPanicRecovered(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	log := &testLogger{}
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0),
+		WithMetrics(reg), WithLogger(log), WithPeerFilter(func(pi pstore.PeerInfo) bool {
+			if pi.ID == testPeer(2) {
+				panic("bad peer")
+			}
+			return true
+		}))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[0 1 3]" {
+		t.Errorf("expected the crawl to go on past the panic and record peers 0, 1 and 3, got %v", got)
+	}
+	if !log.logged("ERROR Recovered from panic crawling " + testPeer(2).Pretty() + ": bad peer") {
+		t.Error("expected the recovered panic to be logged")
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var panics float64
+	for _, mf := range mfs {
+		if mf.GetName() == "ipfs_crawl_panics_recovered_total" {
+			panics = mf.GetMetric()[0].GetCounter().GetValue()
+		}
+	}
+	if panics != 1 {
+		t.Errorf("expected 1 recovered panic to be counted, got %v", panics)
+	}
+}
@@ -0,0 +1,49 @@
+package crawl
+
+// Pause stops the crawl from starting new rounds and the workers from dialing
+// queued peers until Resume is called. Rounds and dials already in progress
+// are allowed to finish, and no state is discarded.
+func (c *Crawler) Pause() {
+	c.pauseMx.Lock()
+	defer c.pauseMx.Unlock()
+	if c.resumed == nil {
+		c.log.Infof("Pausing crawl")
+		c.resumed = make(chan struct{})
+	}
+}
+
+// Resume restarts a crawl stopped by Pause.
+func (c *Crawler) Resume() {
+	c.pauseMx.Lock()
+	defer c.pauseMx.Unlock()
+	if c.resumed != nil {
+		c.log.Infof("Resuming crawl")
+		close(c.resumed)
+		c.resumed = nil
+	}
+}
+
+// Paused returns true if the crawl is paused.
+func (c *Crawler) Paused() bool {
+	c.pauseMx.Lock()
+	defer c.pauseMx.Unlock()
+	return c.resumed != nil
+}
+
+// waitResumed blocks while the crawl is paused, returning false if the
+// crawler is closed meanwhile.
+func (c *Crawler) waitResumed() bool {
+	c.pauseMx.Lock()
+	ch := c.resumed
+	c.pauseMx.Unlock()
+	if ch == nil {
+		return true
+	}
+
+	select {
+	case <-ch:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,45 @@
+package crawl
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPauseResume(t *testing.T) {
+	// each anchor leads to a new peer
+	d := newMockDHT(nil)
+	var anchors []string
+	for n := 0; n < 1000; n++ {
+		a := fmt.Sprintf("a%d", n)
+		d.closestTo(a, n)
+		anchors = append(anchors, a)
+	}
+	h := newMockHost()
+	c := newTestCrawler(t, h, d, WithAnchorStrategy(sequence(anchors...)))
+	defer c.Close()
+
+	go c.Crawl()
+	waitFor(t, "the crawl to start", func() bool { return c.PeerCount() >= 2 })
+
+	c.Pause()
+	if !c.Paused() {
+		t.Fatal("expected the crawl to be paused")
+	}
+	// let the round and dials in progress finish
+	time.Sleep(50 * time.Millisecond)
+	peers, dials := c.PeerCount(), h.totalConnects()
+	time.Sleep(100 * time.Millisecond)
+	if n := c.PeerCount(); n != peers {
+		t.Errorf("expected no new peers while paused, got %d more", n-peers)
+	}
+	if n := h.totalConnects(); n != dials {
+		t.Errorf("expected no dials while paused, got %d more", n-dials)
+	}
+
+	c.Resume()
+	if c.Paused() {
+		t.Fatal("expected the crawl to be resumed")
+	}
+	waitFor(t, "the crawl to make progress again", func() bool { return c.PeerCount() > peers })
+}
@@ -0,0 +1,235 @@
+package crawl
+
+import (
+	"sync"
+	"time"
+
+	bloom "github.com/ipfs/bbloom"
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+// visited is the storage backing a peerSet.
+type visited interface {
+	has(p peer.ID) bool
+	add(p peer.ID)
+	len() int
+	// list returns the stored peers, or nil if they cannot be enumerated.
+	list() []peer.ID
+}
+
+type mapVisited map[peer.ID]struct{}
+
+func (m mapVisited) has(p peer.ID) bool {
+	_, ok := m[p]
+	return ok
+}
+
+func (m mapVisited) add(p peer.ID) { m[p] = struct{}{} }
+func (m mapVisited) len() int      { return len(m) }
+
+func (m mapVisited) list() []peer.ID {
+	ps := make([]peer.ID, 0, len(m))
+	for p := range m {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// bloomVisited tracks visited peers in fixed memory. False positives cause
+// some unvisited peers to be skipped, and the peers cannot be enumerated.
+type bloomVisited struct {
+	f *bloom.Bloom
+	n int
+}
+
+func newBloomVisited(n uint, fp float64) *bloomVisited {
+	// New only fails on negative parameters, which WithVisitedBloom rejects.
+	f, _ := bloom.New(float64(n), fp)
+	return &bloomVisited{f: f}
+}
+
+func (b *bloomVisited) has(p peer.ID) bool { return b.f.Has([]byte(p)) }
+func (b *bloomVisited) len() int           { return b.n }
+func (b *bloomVisited) list() []peer.ID    { return nil }
+
+func (b *bloomVisited) add(p peer.ID) {
+	b.f.Add([]byte(p))
+	b.n++
+}
+
+// PeerMeta records when the crawl first visited a peer, when it last
+// encountered it again and when it last looked it up, which is more recent
+// than FirstSeen if WithRefreshInterval is set. Anchor is the anchor of the
+// round that first visited the peer; it is empty for seed peers and peers
+// loaded from a state file.
+type PeerMeta struct {
+	FirstSeen     time.Time
+	LastSeen      time.Time
+	LastProcessed time.Time
+	Anchor        string
+}
+
+// peerSet is the set of peers visited by the crawl, optionally bounded to max
+// peers, along with the peers each visited peer reported being connected to
+// and when each was seen. The graph and metadata are not recorded if edges
+// and metas are nil. Peers being looked up are held in pending so that
+// concurrent traversals don't crawl them twice.
+type peerSet struct {
+	mx      sync.RWMutex
+	v       visited
+	max     int
+	edges   map[peer.ID][]peer.ID
+	metas   map[peer.ID]PeerMeta
+	pending map[peer.ID]struct{}
+}
+
+func newPeerSet(v visited, max int, detailed bool) *peerSet {
+	s := &peerSet{v: v, max: max, pending: make(map[peer.ID]struct{})}
+	if detailed {
+		s.edges = make(map[peer.ID][]peer.ID)
+		s.metas = make(map[peer.ID]PeerMeta)
+	}
+	return s
+}
+
+func (s *peerSet) seen(p peer.ID) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.v.has(p)
+}
+
+// claim marks p as being looked up, returning false if it is being looked up
+// by another traversal or has already been visited. A visited peer last
+// looked up more than refresh ago is claimed again, and refreshing is set.
+func (s *peerSet) claim(p peer.ID, refresh time.Duration) (claimed, refreshing bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if _, ok := s.pending[p]; ok {
+		return false, false
+	}
+	if s.v.has(p) {
+		if !s.dueLocked(p, refresh) {
+			return false, false
+		}
+		m := s.metas[p]
+		m.LastProcessed = time.Now()
+		s.metas[p] = m
+		refreshing = true
+	}
+	s.pending[p] = struct{}{}
+	return true, refreshing
+}
+
+// due reports whether p was visited and last looked up more than refresh
+// ago.
+func (s *peerSet) due(p peer.ID, refresh time.Duration) bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.dueLocked(p, refresh)
+}
+
+func (s *peerSet) dueLocked(p peer.ID, refresh time.Duration) bool {
+	m, ok := s.metas[p]
+	return refresh > 0 && ok && time.Since(m.LastProcessed) >= refresh
+}
+
+func (s *peerSet) release(p peer.ID) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	delete(s.pending, p)
+}
+
+// markSeen adds p, found from anchor, to the set, returning false if it was
+// already present or the set is full.
+func (s *peerSet) markSeen(p peer.ID, anchor string) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.v.has(p) {
+		return false
+	}
+	if s.max > 0 && s.v.len() >= s.max {
+		return false
+	}
+	s.v.add(p)
+	if s.metas != nil {
+		now := time.Now()
+		s.metas[p] = PeerMeta{FirstSeen: now, LastSeen: now, LastProcessed: now, Anchor: anchor}
+	}
+	return true
+}
+
+// touch updates when p was last seen, returning false if p hasn't been
+// visited.
+func (s *peerSet) touch(p peer.ID) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.v.has(p) {
+		return false
+	}
+	if m, ok := s.metas[p]; ok {
+		m.LastSeen = time.Now()
+		s.metas[p] = m
+	}
+	return true
+}
+
+func (s *peerSet) meta(p peer.ID) (PeerMeta, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	m, ok := s.metas[p]
+	return m, ok
+}
+
+func (s *peerSet) full() bool {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.max > 0 && s.v.len() >= s.max
+}
+
+func (s *peerSet) len() int {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.v.len()
+}
+
+func (s *peerSet) list() []peer.ID {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return s.v.list()
+}
+
+func (s *peerSet) setEdges(p peer.ID, ns []peer.ID) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if s.edges != nil {
+		s.edges[p] = ns
+	}
+}
+
+// sources returns the peers whose neighbors were recorded.
+func (s *peerSet) sources() []peer.ID {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	ps := make([]peer.ID, 0, len(s.edges))
+	for p := range s.edges {
+		ps = append(ps, p)
+	}
+	return ps
+}
+
+// neighbors returns the neighbors recorded for p.
+func (s *peerSet) neighbors(p peer.ID) []peer.ID {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	return append([]peer.ID(nil), s.edges[p]...)
+}
+
+func (s *peerSet) graph() map[peer.ID][]peer.ID {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	g := make(map[peer.ID][]peer.ID, len(s.edges))
+	for p, ns := range s.edges {
+		g[p] = append([]peer.ID(nil), ns...)
+	}
+	return g
+}
@@ -0,0 +1,119 @@
+package crawl
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBloomVisitedBounded(t *testing.T) {
+	const n = 200
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(n-1), 0), WithVisitedBloom(n, 0.01))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	// the filter is sized up front, so only per-peer storage could grow
+	if c.peers.edges != nil || c.peers.metas != nil {
+		t.Error("expected no per-peer graph or metadata with a bloom filter")
+	}
+	if ps := c.SnapshotPeers(); ps != nil {
+		t.Errorf("expected no snapshot from a bloom filter, got %d peers", len(ps))
+	}
+	if fr := c.peers.v.(*bloomVisited).f.FillRatio(); fr >= 0.75 {
+		t.Errorf("expected the filter not to be saturated, got a fill ratio of %v", fr)
+	}
+	if pc := c.PeerCount(); pc < n*9/10 {
+		t.Errorf("expected most of the %d peers visited, got %d", n, pc)
+	}
+}
+
+func TestPeerMetaLastSeen(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0))
+	defer c.Close()
+
+	var metas []PeerMeta
+	for i := 0; i < 3; i++ {
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		m, ok := c.PeerMeta(testPeer(0))
+		if !ok {
+			t.Fatal("no metadata for peer 0")
+		}
+		metas = append(metas, m)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 1; i < len(metas); i++ {
+		if !metas[i].FirstSeen.Equal(metas[0].FirstSeen) {
+			t.Errorf("first seen changed in round %d: %s != %s", i, metas[i].FirstSeen, metas[0].FirstSeen)
+		}
+		if !metas[i].LastSeen.After(metas[i-1].LastSeen) {
+			t.Errorf("last seen didn't advance in round %d: %s", i, metas[i].LastSeen)
+		}
+	}
+}
+
+func TestPeerMetaAnchor(t *testing.T) {
+	d := newMockDHT(map[int][]int{0: {2}, 1: {0, 3}})
+	d.closestTo("a", 0)
+	d.closestTo("b", 1)
+	c := newTestCrawler(t, newMockHost(), d, WithAnchorStrategy(sequence("a", "b")))
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := c.CrawlRound(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for n, want := range []string{"a", "b", "a", "b"} {
+		m, ok := c.PeerMeta(testPeer(n))
+		if !ok {
+			t.Errorf("no metadata for peer %d", n)
+			continue
+		}
+		if m.Anchor != want {
+			t.Errorf("expected peer %d to be first found from anchor %s, got %q", n, want, m.Anchor)
+		}
+	}
+}
+
+func TestPeerSetConcurrent(t *testing.T) {
+	const goroutines, n = 16, 200
+
+	for _, max := range []int{0, n / 4} {
+		s := newPeerSet(make(mapVisited), max, true)
+
+		// every goroutine marks every peer; each must be added exactly once
+		var added int64
+		var wg sync.WaitGroup
+		for g := 0; g < goroutines; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					p := testPeer((i + g) % n)
+					if s.markSeen(p, "a") {
+						atomic.AddInt64(&added, 1)
+					}
+					if !s.seen(p) && !s.full() {
+						t.Errorf("expected peer %d to be seen once marked", (i+g)%n)
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+
+		want := n
+		if max > 0 {
+			want = max
+		}
+		if added != int64(want) || s.len() != want {
+			t.Errorf("max %d: expected %d peers added once, got %d added and %d in the set", max, want, added, s.len())
+		}
+	}
+}
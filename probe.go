@@ -0,0 +1,76 @@
+package crawl
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-host"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ping "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+	manet "github.com/multiformats/go-multiaddr-net"
+)
+
+const pingCount = 3
+
+// pingRTT pings p a few times and returns the median round-trip time, or zero
+// if p cannot be pinged.
+func (c *Crawler) pingRTT(p peer.ID) time.Duration {
+	h, ok := c.h.(host.Host)
+	if !ok {
+		return 0
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.dialTimeout)
+	defer cancel()
+
+	ch, err := ping.Ping(ctx, h, p)
+	if err != nil {
+		c.log.Debugf("Error pinging %s: %s", p.Pretty(), err.Error())
+		return 0
+	}
+
+	// don't rely on ping closing the channel promptly once ctx is done
+	var rtts []time.Duration
+loop:
+	for len(rtts) < pingCount {
+		select {
+		case rtt, ok := <-ch:
+			if !ok {
+				break loop
+			}
+			rtts = append(rtts, rtt)
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	if len(rtts) == 0 {
+		return 0
+	}
+
+	sort.Slice(rtts, func(i, j int) bool { return rtts[i] < rtts[j] })
+	return rtts[len(rtts)/2]
+}
+
+// reachability infers whether a connected peer is publicly reachable: it is
+// if we are connected to it on a public address, and it is not if it only
+// advertises private addresses. Anything else is inconclusive.
+func (c *Crawler) reachability(pi pstore.PeerInfo) Reachability {
+	for _, conn := range c.h.Network().ConnsToPeer(pi.ID) {
+		if manet.IsPublicAddr(conn.RemoteMultiaddr()) {
+			return ReachabilityPublic
+		}
+	}
+
+	if len(pi.Addrs) == 0 {
+		return ReachabilityUnknown
+	}
+	for _, a := range pi.Addrs {
+		if manet.IsPublicAddr(a) {
+			return ReachabilityUnknown
+		}
+	}
+
+	return ReachabilityPrivate
+}
@@ -0,0 +1,110 @@
+package crawl
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	libp2p "github.com/libp2p/go-libp2p"
+	host "github.com/libp2p/go-libp2p-host"
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	ping "github.com/libp2p/go-libp2p/p2p/protocol/ping"
+)
+
+// newLoopbackHost returns a libp2p host listening on a loopback port.
+func newLoopbackHost(t *testing.T) host.Host {
+	t.Helper()
+	h, err := libp2p.New(context.Background(), libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// hostDHT returns a mock DHT whose closest peers are hs, found at their
+// listen addresses.
+func hostDHT(hs ...host.Host) *mockDHT {
+	d := newMockDHT(nil)
+	for _, h := range hs {
+		d.roots = append(d.roots, h.ID())
+		d.addrs[h.ID()] = h.Addrs()
+	}
+	return d
+}
+
+func TestPingRTT(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	h, target := newLoopbackHost(t), newLoopbackHost(t)
+	defer h.Close()
+	defer target.Close()
+	// echo pings back after a delay
+	target.SetStreamHandler(ping.ID, func(s inet.Stream) {
+		defer s.Reset()
+		buf := make([]byte, ping.PingSize)
+		for {
+			if _, err := io.ReadFull(s, buf); err != nil {
+				return
+			}
+			time.Sleep(delay)
+			if _, err := s.Write(buf); err != nil {
+				return
+			}
+		}
+	})
+
+	c := newTestCrawler(t, h, hostDHT(target), WithPrivateAddrs(true), WithPing(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(recs))
+	}
+	if rtt := recs[0].PingRTT; rtt < delay || rtt > delay+50*time.Millisecond {
+		t.Errorf("expected a ping RTT of about %s, got %s", delay, rtt)
+	}
+}
+
+func TestPingDisabled(t *testing.T) {
+	h, target := newLoopbackHost(t), newLoopbackHost(t)
+	defer h.Close()
+	defer target.Close()
+
+	c := newTestCrawler(t, h, hostDHT(target), WithPrivateAddrs(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 || recs[0].PingRTT != 0 {
+		t.Fatalf("expected a record without a ping RTT, got %v", recs)
+	}
+}
+
+func TestReachability(t *testing.T) {
+	d := newMockDHT(starGraph(2), 0)
+	d.setAddrs(1, "/ip4/192.168.1.1/tcp/4001", "/ip4/127.0.0.1/tcp/4001")
+	// a peer connected to on a private address that also advertises a
+	// public one
+	d.setAddrs(2, "/ip4/10.0.0.1/tcp/4001", "/ip4/8.0.0.2/tcp/4001")
+	c := newTestCrawler(t, newMockHost(), d, WithPrivateAddrs(true))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	want := map[peer.ID]Reachability{
+		testPeer(0): ReachabilityPublic,
+		testPeer(1): ReachabilityPrivate,
+		testPeer(2): ReachabilityUnknown,
+	}
+	if len(recs) != len(want) {
+		t.Fatalf("expected %d records, got %d", len(want), len(recs))
+	}
+	for _, rec := range recs {
+		if r := want[rec.PeerInfo.ID]; rec.Reachability != r {
+			t.Errorf("expected %s to be %s, got %s", rec.PeerInfo.ID.Pretty(), r, rec.Reachability)
+		}
+	}
+}
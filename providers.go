@@ -0,0 +1,85 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// maxProviders caps the number of providers CrawlProviders crawls from.
+const maxProviders = 1000
+
+// providerFinder is implemented by DHTs that can look up content providers,
+// such as *dht.IpfsDHT.
+type providerFinder interface {
+	FindProvidersAsync(ctx context.Context, key cid.Cid, count int) <-chan pstore.PeerInfo
+}
+
+var _ providerFinder = (*dht.IpfsDHT)(nil)
+
+// CrawlProviders crawls from the peers providing key in the DHT passed to
+// NewCrawler: they are dialed and recorded like any other peer, and the crawl
+// expands from them. It returns once the traversal completes, or early if
+// ctx is done or the crawler is closed.
+func (c *Crawler) CrawlProviders(ctx context.Context, key cid.Cid) error {
+	ctx, cancel := c.roundContext(ctx)
+	defer cancel()
+
+	d := c.dhts[0]
+	pf, ok := d.dht.(providerFinder)
+	if !ok {
+		return fmt.Errorf("the %s DHT cannot find providers", d.name)
+	}
+
+	pis, err := c.findProviders(ctx, pf, key)
+	if err != nil {
+		return fmt.Errorf("error finding providers of %s: %s", key, err)
+	}
+	if len(pis) == 0 {
+		c.log.Infof("Found no providers of %s", key)
+		return ctx.Err()
+	}
+
+	c.log.Infof("Crawling from %d providers of %s", len(pis), key)
+	ps := make([]peer.ID, 0, len(pis))
+	for _, pi := range pis {
+		c.h.Peerstore().AddAddrs(pi.ID, pi.Addrs, pstore.TempAddrTTL)
+		ps = append(ps, pi.ID)
+	}
+
+	r := &round{ctx: ctx, anchor: key.String(), dht: d}
+	c.traverse(ps, r)
+	c.endRound(r)
+
+	return ctx.Err()
+}
+
+func (c *Crawler) findProviders(ctx context.Context, pf providerFinder, key cid.Cid) (pis []pstore.PeerInfo, err error) {
+	done, err := c.beginQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	defer c.metrics.observeQuery("FindProviders", time.Now(), &err)
+
+	qctx, cancel := context.WithTimeout(ctx, c.cfg.queryTimeout)
+	defer cancel()
+
+	pch := pf.FindProvidersAsync(qctx, key, maxProviders)
+	for {
+		select {
+		case pi, ok := <-pch:
+			if !ok {
+				return pis, nil
+			}
+			pis = append(pis, pi)
+		case <-qctx.Done():
+			return pis, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// providerDHT is a mock DHT that finds the providers scripted for a key.
+type providerDHT struct {
+	*mockDHT
+	providers map[string][]int
+}
+
+func (d *providerDHT) FindProvidersAsync(ctx context.Context, key cid.Cid, count int) <-chan pstore.PeerInfo {
+	ns := d.providers[key.String()]
+	ch := make(chan pstore.PeerInfo, len(ns))
+	for _, n := range ns {
+		p := d.add(n)
+		ch <- pstore.PeerInfo{ID: p, Addrs: d.addrs[p]}
+	}
+	close(ch)
+	return ch
+}
+
+func mustCid(t *testing.T, s string) cid.Cid {
+	t.Helper()
+	c, err := cid.Decode(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestCrawlProviders(t *testing.T) {
+	key := mustCid(t, "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	// the crawl expands from provider 2 to peer 3
+	d := &providerDHT{
+		mockDHT:   newMockDHT(map[int][]int{2: {3}}, 0),
+		providers: map[string][]int{key.String(): {1, 2}},
+	}
+	c := newTestCrawler(t, newMockHost(), d)
+
+	if err := c.CrawlProviders(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	c.drain()
+	var recs []PeerRecord
+	for rec := range c.Records {
+		recs = append(recs, rec)
+	}
+
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("expected the providers and their neighbors to be recorded, got %v", got)
+	}
+	if keys := d.queried(); len(keys) != 0 {
+		t.Errorf("expected no anchor queries, got %v", keys)
+	}
+}
+
+func TestCrawlProvidersNone(t *testing.T) {
+	log := &testLogger{}
+	d := &providerDHT{mockDHT: newMockDHT(nil, 0)}
+	c := newTestCrawler(t, newMockHost(), d, WithLogger(log))
+
+	key := mustCid(t, "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n")
+	if err := c.CrawlProviders(context.Background(), key); err != nil {
+		t.Fatalf("expected no providers not to be an error, got %s", err)
+	}
+	c.drain()
+	if n := len(c.Records); n != 0 {
+		t.Errorf("expected no records, got %d", n)
+	}
+	if !log.logged("INFO Found no providers of " + key.String()) {
+		t.Error("expected the lack of providers to be logged")
+	}
+}
+
+func TestCrawlProvidersUnsupported(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0))
+	defer c.Close()
+
+	err := c.CrawlProviders(context.Background(), mustCid(t, "QmdfTbBqBPQ7VNxZEYEj14VmRuZBkqFbiwReogJgS1zR1n"))
+	if err == nil || !strings.Contains(err.Error(), "cannot find providers") {
+		t.Errorf("expected a DHT without provider lookups to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package crawl
+
+import (
+	"context"
+	"time"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// DHT is the subset of the DHT queried by the crawler.
+type DHT interface {
+	GetClosestPeers(ctx context.Context, key string) (<-chan peer.ID, error)
+	FindPeer(ctx context.Context, p peer.ID) (pstore.PeerInfo, error)
+	FindPeersConnectedToPeer(ctx context.Context, p peer.ID) (<-chan *pstore.PeerInfo, error)
+}
+
+var _ DHT = (*dht.IpfsDHT)(nil)
+
+// PrimaryDHT is the name records and round summaries give the DHT passed to
+// NewCrawler.
+const PrimaryDHT = "primary"
+
+// namedDHT is a DHT crawled by the crawler and the name its peers are tagged
+// with.
+type namedDHT struct {
+	name string
+	dht  DHT
+}
+
+// beginQuery waits for the query rate limit and, if WithMaxConcurrentQueries
+// is set, a free query slot. The returned function releases the slot.
+func (c *Crawler) beginQuery(ctx context.Context) (func(), error) {
+	if err := c.queryLimit.Wait(ctx); err != nil {
+		return nil, err
+	}
+	if c.querySem == nil {
+		return func() {}, nil
+	}
+	if err := c.querySem.Acquire(ctx); err != nil {
+		return nil, err
+	}
+	return func() { c.querySem.Release() }, nil
+}
+
+// queryTimeout returns d, or the general query timeout if d is unset.
+func (c *Crawler) queryTimeout(d time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return c.cfg.queryTimeout
+}
+
+func (c *Crawler) closestPeers(ctx context.Context, d DHT, key string) (ps []peer.ID, err error) {
+	done, err := c.beginQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	defer c.metrics.observeQuery("GetClosestPeers", time.Now(), &err)
+
+	qctx, cancel := context.WithTimeout(ctx, c.cfg.queryTimeout)
+	defer cancel()
+
+	pch, err := d.GetClosestPeers(qctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// don't rely on the DHT closing the channel promptly once qctx is done
+	for {
+		select {
+		case p, ok := <-pch:
+			if !ok {
+				return ps, nil
+			}
+			ps = append(ps, p)
+		case <-qctx.Done():
+			// a timed out query keeps what it found; a cancelled one fails
+			return ps, ctx.Err()
+		}
+	}
+}
+
+// anchorPeers returns the peers closest to key, retrying failed queries with
+// exponential backoff as set by WithQueryRetries.
+func (c *Crawler) anchorPeers(ctx context.Context, d DHT, key string) ([]peer.ID, error) {
+	delay := c.cfg.queryRetryBase
+	for i := 0; ; i++ {
+		ps, err := c.closestPeers(ctx, d, key)
+		if err == nil || i >= c.cfg.queryRetries || ctx.Err() != nil {
+			return ps, err
+		}
+
+		c.log.Debugf("Error finding peers closest to %s, retrying in %s: %s", key, delay, err.Error())
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+func (c *Crawler) findPeer(ctx context.Context, d DHT, p peer.ID) (pi pstore.PeerInfo, err error) {
+	done, err := c.beginQuery(ctx)
+	if err != nil {
+		return pstore.PeerInfo{}, err
+	}
+	defer done()
+	defer c.metrics.observeQuery("FindPeer", time.Now(), &err)
+
+	ctx, cancel := context.WithTimeout(ctx, c.queryTimeout(c.cfg.findPeerTimeout))
+	defer cancel()
+
+	return d.FindPeer(ctx, p)
+}
+
+func (c *Crawler) connectedPeers(ctx context.Context, d DHT, p peer.ID) (ps []peer.ID, err error) {
+	done, err := c.beginQuery(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	defer c.metrics.observeQuery("FindPeersConnectedToPeer", time.Now(), &err)
+
+	qctx, cancel := context.WithTimeout(ctx, c.queryTimeout(c.cfg.connectedPeersTimeout))
+	defer cancel()
+
+	pch, err := d.FindPeersConnectedToPeer(qctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		select {
+		case pi, ok := <-pch:
+			if !ok {
+				return ps, nil
+			}
+			ps = append(ps, pi.ID)
+		case <-qctx.Done():
+			return ps, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+func TestQueryRate(t *testing.T) {
+	const every = 20 * time.Millisecond
+
+	var mx sync.Mutex
+	var starts []time.Time
+	d := newMockDHT(starGraph(4), 0)
+	d.query = func(ctx context.Context, op string) error {
+		mx.Lock()
+		defer mx.Unlock()
+		starts = append(starts, time.Now())
+		return nil
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithQueryRate(float64(time.Second/every), 1))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	// a GetClosestPeers, and a FindPeer and FindPeersConnectedToPeer for
+	// each peer
+	if len(starts) != 11 {
+		t.Fatalf("expected 11 queries, got %d", len(starts))
+	}
+	// a query starting late doesn't push back the ones after it, so check
+	// each against the schedule rather than the query before it, allowing
+	// for timer slack
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+	for i := 1; i < len(starts); i++ {
+		if elapsed := starts[i].Sub(starts[0]); elapsed < time.Duration(i)*every-every/4 {
+			t.Errorf("query %d started %s after the first, before its slot at %s", i, elapsed, time.Duration(i)*every)
+		}
+	}
+}
+
+func TestMaxConcurrentQueries(t *testing.T) {
+	const max = 2
+
+	var mx sync.Mutex
+	var inflight, peak int
+	d := newMockDHT(starGraph(8), 0)
+	d.query = func(ctx context.Context, op string) error {
+		mx.Lock()
+		inflight++
+		if inflight > peak {
+			peak = inflight
+		}
+		mx.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+
+		mx.Lock()
+		inflight--
+		mx.Unlock()
+		return nil
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithWorkers(8), WithExpanders(8),
+		WithMaxConcurrentQueries(max))
+	defer c.Close()
+
+	if recs := crawlOnce(t, c); len(recs) != 9 {
+		t.Fatalf("expected 9 records, got %d", len(recs))
+	}
+	if peak > max {
+		t.Errorf("expected at most %d queries in flight, got %d", max, peak)
+	}
+	if peak < max {
+		t.Errorf("expected the workers to fill the %d query slots, got %d", max, peak)
+	}
+}
+
+func TestQueryTimeouts(t *testing.T) {
+	for _, tc := range []struct {
+		opts  []Option
+		wants map[string]time.Duration
+	}{
+		{nil, map[string]time.Duration{
+			"GetClosestPeers":          60 * time.Second,
+			"FindPeer":                 60 * time.Second,
+			"FindPeersConnectedToPeer": 60 * time.Second,
+		}},
+		{[]Option{WithQueryTimeout(30 * time.Second), WithFindPeerTimeout(20 * time.Second), WithConnectedPeersTimeout(5 * time.Second)}, map[string]time.Duration{
+			"GetClosestPeers":          30 * time.Second,
+			"FindPeer":                 20 * time.Second,
+			"FindPeersConnectedToPeer": 5 * time.Second,
+		}},
+		// the lookups fall back to the general query timeout
+		{[]Option{WithQueryTimeout(30 * time.Second), WithConnectedPeersTimeout(5 * time.Second)}, map[string]time.Duration{
+			"GetClosestPeers":          30 * time.Second,
+			"FindPeer":                 30 * time.Second,
+			"FindPeersConnectedToPeer": 5 * time.Second,
+		}},
+	} {
+		var mx sync.Mutex
+		timeouts := make(map[string]time.Duration)
+		d := newMockDHT(nil, 0)
+		d.query = func(ctx context.Context, op string) error {
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				return nil
+			}
+			mx.Lock()
+			defer mx.Unlock()
+			timeouts[op] = time.Until(deadline)
+			return nil
+		}
+		c := newTestCrawler(t, newMockHost(), d, tc.opts...)
+		crawlOnce(t, c)
+		c.Close()
+
+		for op, want := range tc.wants {
+			// the deadline is checked a moment after it was set
+			if got := timeouts[op]; got > want || got < want-time.Second {
+				t.Errorf("expected %s to time out after %s, got %s", op, want, got)
+			}
+		}
+	}
+}
+
+func TestQueryRetries(t *testing.T) {
+	const base = 10 * time.Millisecond
+
+	var mx sync.Mutex
+	var attempts []time.Time
+	d := newMockDHT(map[int][]int{0: {1}}, 0)
+	d.query = func(ctx context.Context, op string) error {
+		if op != "GetClosestPeers" {
+			return nil
+		}
+		mx.Lock()
+		defer mx.Unlock()
+		attempts = append(attempts, time.Now())
+		if len(attempts) <= 2 {
+			return errors.New("connection reset")
+		}
+		return nil
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithQueryRetries(2, base))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatalf("expected the third attempt to succeed, got %s", err)
+	}
+	c.drain()
+	var recs []PeerRecord
+	for rec := range c.Records {
+		recs = append(recs, rec)
+	}
+
+	if len(attempts) != 3 {
+		t.Fatalf("expected 3 attempts, got %d", len(attempts))
+	}
+	// the backoff doubles from base
+	for i, want := range []time.Duration{base, 2 * base} {
+		if gap := attempts[i+1].Sub(attempts[i]); gap < want {
+			t.Errorf("expected retry %d after %s, got %s", i+1, want, gap)
+		}
+	}
+	if got := recorded(recs, 1); fmt.Sprint(got) != "[0 1]" {
+		t.Errorf("expected the peers found by the third attempt to be crawled, got %v", got)
+	}
+}
+
+func TestQueryRetriesExhausted(t *testing.T) {
+	d := newMockDHT(nil, 0)
+	d.query = func(ctx context.Context, op string) error {
+		return errors.New("connection reset")
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithQueryRetries(2, time.Millisecond))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err == nil {
+		t.Error("expected the round to fail once the retries ran out")
+	}
+	if n := len(d.queried()); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+}
+
+func TestQueryRetriesCancelled(t *testing.T) {
+	d := newMockDHT(nil, 0)
+	d.query = func(ctx context.Context, op string) error {
+		return errors.New("connection reset")
+	}
+	c := newTestCrawler(t, newMockHost(), d, WithQueryRetries(5, time.Hour))
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.CrawlRound(ctx); err == nil {
+		t.Error("expected the cancelled round to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected cancellation to abort the retries, took %s", elapsed)
+	}
+	if n := len(d.queried()); n != 1 {
+		t.Errorf("expected no retries after cancellation, got %d attempts", n)
+	}
+}
+
+// hangingDHT is a mock DHT whose GetClosestPeers sends the roots but never
+// closes the channel.
+type hangingDHT struct {
+	*mockDHT
+}
+
+func (d *hangingDHT) GetClosestPeers(ctx context.Context, key string) (<-chan peer.ID, error) {
+	ch := make(chan peer.ID, len(d.roots))
+	for _, p := range d.roots {
+		ch <- p
+	}
+	return ch, nil
+}
+
+func TestClosestPeersCancelled(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), &hangingDHT{newMockDHT(nil, 0)})
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := c.CrawlRound(ctx); err == nil {
+		t.Error("expected the cancelled round to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the round to return on cancellation, took %s", elapsed)
+	}
+}
+
+func TestClosestPeersClosed(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), &hangingDHT{newMockDHT(nil, 0)})
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.CrawlRound(context.Background()) }()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+
+	select {
+	case <-errc:
+	case <-time.After(time.Second):
+		t.Fatal("expected the round to return when the crawler is closed")
+	}
+}
+
+func TestClosestPeersTimeout(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), &hangingDHT{newMockDHT(nil, 0)}, WithQueryTimeout(20*time.Millisecond))
+
+	// a timed out query keeps the peers it found
+	if recs := crawlOnce(t, c); len(recs) != 1 {
+		t.Errorf("expected the peer found before the timeout to be crawled, got %d records", len(recs))
+	}
+}
@@ -0,0 +1,101 @@
+package crawl
+
+import (
+	"container/heap"
+	"sync"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+// addrCount is the default peer score: peers advertising more addresses are
+// more likely to be reachable.
+func addrCount(pi pstore.PeerInfo) int { return len(pi.Addrs) }
+
+// workQueue is a bounded priority queue of records waiting to be dialed,
+// highest score first. A sender takes a slot before pushing and a receiver a
+// ready token before popping, so that both can wait on the queue in a select.
+type workQueue struct {
+	slots chan struct{}
+	ready chan struct{}
+	score func(pstore.PeerInfo) int
+
+	mx   sync.Mutex
+	recs recordHeap
+	seq  uint64
+}
+
+func newWorkQueue(size int, score func(pstore.PeerInfo) int) *workQueue {
+	return &workQueue{
+		slots: make(chan struct{}, size),
+		ready: make(chan struct{}, size),
+		score: score,
+	}
+}
+
+// push queues rec; the caller must hold a slot. The slot is released if the
+// score function panics.
+func (q *workQueue) push(rec PeerRecord) {
+	scored := false
+	defer func() {
+		if !scored {
+			<-q.slots
+		}
+	}()
+	sr := scoredRecord{rec: rec, score: q.score(rec.PeerInfo)}
+	scored = true
+
+	q.mx.Lock()
+	sr.seq = q.seq
+	q.seq++
+	heap.Push(&q.recs, sr)
+	q.mx.Unlock()
+
+	q.ready <- struct{}{}
+}
+
+// pop dequeues the best record, releasing its slot; the caller must hold a
+// ready token.
+func (q *workQueue) pop() PeerRecord {
+	q.mx.Lock()
+	sr := heap.Pop(&q.recs).(scoredRecord)
+	q.mx.Unlock()
+
+	<-q.slots
+	return sr.rec
+}
+
+func (q *workQueue) len() int {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return len(q.recs)
+}
+
+type scoredRecord struct {
+	rec   PeerRecord
+	score int
+	seq   uint64
+}
+
+// recordHeap orders records by descending score, then in the order they
+// were queued.
+type recordHeap []scoredRecord
+
+func (h recordHeap) Len() int { return len(h) }
+
+func (h recordHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h recordHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *recordHeap) Push(x interface{}) { *h = append(*h, x.(scoredRecord)) }
+
+func (h *recordHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,177 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestPeersWithMoreAddrsDialedFirst(t *testing.T) {
+	// the root outranks its neighbors, so it is dialed first whenever the
+	// worker gets to the queue
+	d := newMockDHT(starGraph(4), 0)
+	for n, count := range map[int]int{0: 5, 1: 1, 2: 3, 3: 2, 4: 4} {
+		var addrs []string
+		for i := 0; i < count; i++ {
+			addrs = append(addrs, fmt.Sprintf("/ip4/8.0.%d.%d/tcp/4001", n, i))
+		}
+		d.setAddrs(n, addrs...)
+	}
+
+	// hold the single worker on the first dial until the other peers are
+	// queued behind it
+	release := make(chan struct{})
+	var mx sync.Mutex
+	var order []int
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		mx.Lock()
+		for n := 0; n <= 4; n++ {
+			if pi.ID == testPeer(n) {
+				order = append(order, n)
+			}
+		}
+		first := len(order) == 1
+		mx.Unlock()
+
+		if first {
+			<-release
+		}
+		return nil
+	}
+	c := newTestCrawler(t, h, d, WithAutoScale(1, 8))
+	defer c.Close()
+
+	done := make(chan error)
+	go func() {
+		err := c.CrawlRound(context.Background())
+		c.drain()
+		done <- err
+	}()
+	waitFor(t, "the peers to be queued", func() bool { return c.QueueDepth() == 4 })
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(order) != "[0 4 2 3 1]" {
+		t.Errorf("expected the peers to be dialed by address count, got %v", order)
+	}
+}
+
+func TestPeerScore(t *testing.T) {
+	// rank peers by their number, the highest first
+	q := newWorkQueue(4, func(pi pstore.PeerInfo) int {
+		for n := 0; ; n++ {
+			if pi.ID == testPeer(n) {
+				return n
+			}
+		}
+	})
+	for _, n := range []int{2, 0, 3, 1} {
+		q.slots <- struct{}{}
+		q.push(PeerRecord{PeerInfo: pstore.PeerInfo{ID: testPeer(n)}})
+	}
+
+	var got []int
+	for q.len() > 0 {
+		<-q.ready
+		rec := q.pop()
+		got = append(got, recorded([]PeerRecord{rec}, 3)...)
+	}
+	if fmt.Sprint(got) != "[3 2 1 0]" {
+		t.Errorf("expected the highest scoring peers first, got %v", got)
+	}
+}
+
+func TestPeerScoreOption(t *testing.T) {
+	// rank the root first, then its neighbors by their number
+	score := func(pi pstore.PeerInfo) int {
+		for n := 1; n <= 4; n++ {
+			if pi.ID == testPeer(n) {
+				return n
+			}
+		}
+		return 100
+	}
+
+	// hold the single worker on the first dial until the other peers are
+	// queued behind it
+	release := make(chan struct{})
+	var mx sync.Mutex
+	var order []int
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		mx.Lock()
+		for n := 0; n <= 4; n++ {
+			if pi.ID == testPeer(n) {
+				order = append(order, n)
+			}
+		}
+		first := len(order) == 1
+		mx.Unlock()
+
+		if first {
+			<-release
+		}
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(4), 0), WithAutoScale(1, 8), WithPeerScore(score))
+	defer c.Close()
+
+	done := make(chan error)
+	go func() {
+		err := c.CrawlRound(context.Background())
+		c.drain()
+		done <- err
+	}()
+	waitFor(t, "the peers to be queued", func() bool { return c.QueueDepth() == 4 })
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if fmt.Sprint(order) != "[0 4 3 2 1]" {
+		t.Errorf("expected the peers to be dialed by score, got %v", order)
+	}
+}
+
+func TestPeerScorePanicRecovered(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0), WithLogger(&testLogger{}),
+		WithPeerScore(func(pi pstore.PeerInfo) int {
+			if pi.ID == testPeer(2) {
+				panic("bad score")
+			}
+			return 0
+		}))
+	defer c.Close()
+
+	done := make(chan []PeerRecord)
+	go func() {
+		var recs []PeerRecord
+		if err := c.CrawlRound(context.Background()); err == nil {
+			c.drain()
+			for rec := range c.Records {
+				recs = append(recs, rec)
+			}
+		}
+		done <- recs
+	}()
+
+	var recs []PeerRecord
+	select {
+	case recs = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the crawl hung after the score function panicked")
+	}
+	if got := recorded(recs, 3); fmt.Sprint(got) != "[0 1 3]" {
+		t.Errorf("expected the crawl to go on past the panic and record peers 0, 1 and 3, got %v", got)
+	}
+	if depth, slots := c.QueueDepth(), len(c.work.slots); depth != 0 || slots != 0 {
+		t.Errorf("expected the queue to be empty with no slots held, got %d queued and %d slots", depth, slots)
+	}
+}
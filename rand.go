@@ -0,0 +1,40 @@
+package crawl
+
+import (
+	"context"
+	mrand "math/rand"
+	"sync"
+)
+
+// lockedRand makes a random source given with WithRandSource safe for
+// concurrent use.
+type lockedRand struct {
+	mx sync.Mutex
+	r  *mrand.Rand
+}
+
+func (l *lockedRand) Int63n(n int64) int64 {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.r.Int63n(n)
+}
+
+func (l *lockedRand) Read(b []byte) (int, error) {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.r.Read(b)
+}
+
+// anchor is the random anchor strategy drawing from the source.
+func (l *lockedRand) anchor(ctx context.Context) (string, error) {
+	return randomAnchor(ctx, l.Read)
+}
+
+// int63n returns a random number in [0, n) for backoff, from the source
+// given with WithRandSource if any.
+func (c *Crawler) int63n(n int64) int64 {
+	if c.cfg.rand != nil {
+		return c.cfg.rand.Int63n(n)
+	}
+	return mrand.Int63n(n)
+}
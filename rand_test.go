@@ -0,0 +1,67 @@
+package crawl
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	mrand "math/rand"
+	"testing"
+)
+
+func TestRandSource(t *testing.T) {
+	const seed = 42
+
+	// the anchors are the base64 of the source's successive 32 bytes
+	r := mrand.New(mrand.NewSource(seed))
+	var want []string
+	for i := 0; i < 3; i++ {
+		b := make([]byte, 32)
+		r.Read(b)
+		want = append(want, base64.RawStdEncoding.EncodeToString(b))
+	}
+
+	for i := 0; i < 2; i++ {
+		d := newMockDHT(nil, 0)
+		c := newTestCrawler(t, newMockHost(), d, WithRandSource(mrand.New(mrand.NewSource(seed))))
+		for round := 0; round < 3; round++ {
+			if err := c.CrawlRound(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		c.Close()
+
+		if got := d.queried(); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("crawl %d: expected anchors %v, got %v", i, want, got)
+		}
+	}
+}
+
+func TestRandSourceBackoff(t *testing.T) {
+	draws := func() []int64 {
+		c := newTestCrawler(t, newMockHost(), newMockDHT(nil), WithRandSource(mrand.New(mrand.NewSource(7))))
+		defer c.Close()
+		var ns []int64
+		for i := 0; i < 5; i++ {
+			ns = append(ns, c.int63n(1000))
+		}
+		return ns
+	}
+
+	if a, b := draws(), draws(); fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("expected the same backoff draws from the same seed, got %v and %v", a, b)
+	}
+}
+
+func TestRandSourceAnchorStrategy(t *testing.T) {
+	d := newMockDHT(nil, 0)
+	c := newTestCrawler(t, newMockHost(), d, WithRandSource(mrand.New(mrand.NewSource(1))),
+		WithAnchorStrategy(sequence("x")))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if keys := d.queried(); fmt.Sprint(keys) != "[x]" {
+		t.Errorf("expected the anchor strategy to take precedence, got %v", keys)
+	}
+}
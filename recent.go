@@ -0,0 +1,53 @@
+package crawl
+
+import "sync"
+
+// recentBuffer is a sink keeping the most recent records in a ring buffer.
+type recentBuffer struct {
+	mx   sync.Mutex
+	recs []PeerRecord
+	next int
+	full bool
+}
+
+func newRecentBuffer(n int) *recentBuffer {
+	return &recentBuffer{recs: make([]PeerRecord, n)}
+}
+
+func (b *recentBuffer) put(rec PeerRecord) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.recs[b.next] = rec
+	b.next = (b.next + 1) % len(b.recs)
+	if b.next == 0 {
+		b.full = true
+	}
+}
+
+func (b *recentBuffer) close() error { return nil }
+
+// snapshot returns the buffered records, newest first.
+func (b *recentBuffer) snapshot() []PeerRecord {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	n := b.next
+	if b.full {
+		n = len(b.recs)
+	}
+	out := make([]PeerRecord, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, b.recs[(b.next-i+len(b.recs))%len(b.recs)])
+	}
+	return out
+}
+
+// Recent returns the records most recently emitted on Records, newest
+// first, up to the size set with WithRecentBuffer, including any dropped
+// because Records was full. It returns nil if the buffer is not enabled.
+func (c *Crawler) Recent() []PeerRecord {
+	if c.recent == nil {
+		return nil
+	}
+	return c.recent.snapshot()
+}
@@ -0,0 +1,60 @@
+package crawl
+
+import (
+	"fmt"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestRecentBuffer(t *testing.T) {
+	for _, tc := range []struct {
+		puts int
+		want string
+	}{
+		{0, "[]"},
+		{2, "[1 0]"},
+		{3, "[2 1 0]"},
+		{6, "[5 4 3]"},
+		{7, "[6 5 4]"},
+	} {
+		b := newRecentBuffer(3)
+		for n := 0; n < tc.puts; n++ {
+			b.put(PeerRecord{PeerInfo: pstore.PeerInfo{ID: testPeer(n)}})
+		}
+
+		var got []int
+		for _, rec := range b.snapshot() {
+			got = append(got, recorded([]PeerRecord{rec}, 9)...)
+		}
+		if fmt.Sprint(got) != tc.want {
+			t.Errorf("after %d records: expected %s, got %v", tc.puts, tc.want, got)
+		}
+	}
+}
+
+func TestRecent(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(5), 0), WithWorkers(1), WithRecentBuffer(3))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 6 {
+		t.Fatalf("expected 6 records, got %d", len(recs))
+	}
+	recent := c.Recent()
+	if len(recent) != 3 {
+		t.Fatalf("expected the 3 most recent records to be kept, got %d", len(recent))
+	}
+	for i, rec := range recent {
+		if want := recs[len(recs)-1-i].PeerInfo.ID; rec.PeerInfo.ID != want {
+			t.Errorf("expected recent record %d to be %s, got %s", i, want.Pretty(), rec.PeerInfo.ID.Pretty())
+		}
+	}
+
+	c = newTestCrawler(t, newMockHost(), newMockDHT(nil, 0))
+	crawlOnce(t, c)
+	if recent := c.Recent(); recent != nil {
+		t.Errorf("expected no recent records without the buffer, got %v", recent)
+	}
+}
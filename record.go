@@ -0,0 +1,223 @@
+package crawl
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	inet "github.com/libp2p/go-libp2p-net"
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// PeerRecord describes a peer discovered by the crawl.
+type PeerRecord struct {
+	PeerInfo     pstore.PeerInfo
+	DiscoveredAt time.Time
+	// ResolvedAddrs are the addresses dialed after expanding the DNS
+	// multiaddrs in PeerInfo, if it had any.
+	ResolvedAddrs []ma.Multiaddr
+	// Anchor is the key whose closest peers led the crawl to this peer.
+	Anchor string
+	// DHT is the name of the DHT the peer was found in.
+	DHT string
+	// AgentVersion is the agent version reported by the peer, if identify
+	// completed in time.
+	AgentVersion string
+	// Client is the client implementation inferred from the agent version
+	// by ClassifyAgent, for connected peers.
+	Client string
+	// Protocols is the sorted list of protocols the peer supports, if
+	// identify completed in time.
+	Protocols []string
+	// AdvertisedAddrs are the listen addresses the peer advertised over
+	// identify, and ObservedAddrs the address it observed our host at. Both
+	// are empty for peers that weren't connected to or didn't answer
+	// identify; addresses learned from the DHT are in PeerInfo.
+	AdvertisedAddrs []ma.Multiaddr
+	ObservedAddrs   []ma.Multiaddr
+	// DialLatency is how long the successful connection attempt took.
+	DialLatency time.Duration
+	// PingRTT is the median ping round-trip time, if pinging is enabled and
+	// the peer supports it.
+	PingRTT time.Duration
+	// Reachability is whether the peer appears to be publicly reachable.
+	Reachability Reachability
+	// Connectedness is the host's view of its connection to the peer when
+	// the record was emitted.
+	Connectedness inet.Connectedness
+	// RoutingTableSize estimates the size of the peer's routing table by the
+	// number of peers the DHT reports it connected to. It is zero if unknown,
+	// as for peers at the maximum depth or without a DHT server.
+	RoutingTableSize int
+	// Country is the ISO country code and Latitude and Longitude the
+	// approximate location of the peer's first public address, if GeoIP
+	// lookups are enabled and the address resolved.
+	Country   string
+	Latitude  float64
+	Longitude float64
+
+	// Err is the error the final connection attempt failed with, for records
+	// sent on Failed. DialBackoff is set if the crawler gave up because the
+	// peer stayed in dial backoff.
+	Err         error
+	DialBackoff bool
+}
+
+// Reachability classifies whether a peer is publicly reachable.
+type Reachability int
+
+const (
+	ReachabilityUnknown Reachability = iota
+	ReachabilityPublic
+	ReachabilityPrivate
+)
+
+func (r Reachability) String() string {
+	switch r {
+	case ReachabilityPublic:
+		return "public"
+	case ReachabilityPrivate:
+		return "private"
+	default:
+		return "unknown"
+	}
+}
+
+// connectednessString names c as the JSON form of a record does; the
+// connectedness type of this go-libp2p-net release has no String method.
+func connectednessString(c inet.Connectedness) string {
+	switch c {
+	case inet.Connected:
+		return "Connected"
+	case inet.CanConnect:
+		return "CanConnect"
+	case inet.CannotConnect:
+		return "CannotConnect"
+	default:
+		return "NotConnected"
+	}
+}
+
+type jsonRecord struct {
+	ID           string    `json:"id"`
+	Addrs        []string  `json:"addrs"`
+	Resolved     []string  `json:"resolved_addrs,omitempty"`
+	AgentVersion string    `json:"agent_version,omitempty"`
+	Client       string    `json:"client,omitempty"`
+	Protocols    []string  `json:"protocols,omitempty"`
+	Advertised   []string  `json:"advertised_addrs,omitempty"`
+	Observed     []string  `json:"observed_addrs,omitempty"`
+	Anchor       string    `json:"anchor,omitempty"`
+	DHT          string    `json:"dht,omitempty"`
+	DiscoveredAt time.Time `json:"discovered_at"`
+	Err          string    `json:"error,omitempty"`
+	DialBackoff  bool      `json:"dial_backoff,omitempty"`
+	DialLatency  float64   `json:"dial_latency_ms,omitempty"`
+	PingRTT      float64   `json:"ping_rtt_ms,omitempty"`
+	Reachability string    `json:"reachability,omitempty"`
+	Connected    string    `json:"connectedness"`
+	RoutingTable int       `json:"routing_table_size,omitempty"`
+	Country      string    `json:"country,omitempty"`
+	Latitude     float64   `json:"latitude,omitempty"`
+	Longitude    float64   `json:"longitude,omitempty"`
+}
+
+// MarshalJSON encodes the record with the peer ID and multiaddrs in their
+// string forms.
+func (r PeerRecord) MarshalJSON() ([]byte, error) {
+	jr := jsonRecord{
+		ID:           r.PeerInfo.ID.Pretty(),
+		Addrs:        addrStrings(r.PeerInfo.Addrs),
+		Resolved:     addrStrings(r.ResolvedAddrs),
+		AgentVersion: r.AgentVersion,
+		Client:       r.Client,
+		Protocols:    r.Protocols,
+		Advertised:   addrStrings(r.AdvertisedAddrs),
+		Observed:     addrStrings(r.ObservedAddrs),
+		Anchor:       r.Anchor,
+		DHT:          r.DHT,
+		DiscoveredAt: r.DiscoveredAt,
+		DialBackoff:  r.DialBackoff,
+		DialLatency:  r.DialLatency.Seconds() * 1000,
+		PingRTT:      r.PingRTT.Seconds() * 1000,
+		Reachability: r.Reachability.String(),
+		Connected:    connectednessString(r.Connectedness),
+		RoutingTable: r.RoutingTableSize,
+		Country:      r.Country,
+		Latitude:     r.Latitude,
+		Longitude:    r.Longitude,
+	}
+	if r.Err != nil {
+		jr.Err = r.Err.Error()
+	}
+	return json.Marshal(&jr)
+}
+
+func addrStrings(addrs []ma.Multiaddr) []string {
+	ss := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		ss = append(ss, a.String())
+	}
+	return ss
+}
+
+// RoundSummary describes a completed crawl round: the traversal from one
+// anchor, or from the seed peers.
+type RoundSummary struct {
+	Anchor string
+	DHT    string
+	// NewPeers is the number of peers visited for the first time in the
+	// round, and SeenPeers the number of distinct peers visited in earlier
+	// rounds that the round encountered again.
+	NewPeers   int
+	SeenPeers  int
+	TotalPeers int
+}
+
+// round is the state of the traversal from a single anchor.
+type round struct {
+	ctx    context.Context
+	anchor string
+	dht    namedDHT
+
+	mx       sync.Mutex
+	newPeers int
+	reseen   int
+	// peers is whether each peer encountered in the round was new to it.
+	peers map[peer.ID]bool
+}
+
+// discovered counts p as visited for the first time.
+func (r *round) discovered(p peer.ID) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.peers == nil {
+		r.peers = make(map[peer.ID]bool)
+	}
+	r.peers[p] = true
+	r.newPeers++
+}
+
+// encountered counts p, visited before, as seen again unless the round
+// already encountered it.
+func (r *round) encountered(p peer.ID) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	if r.peers == nil {
+		r.peers = make(map[peer.ID]bool)
+	}
+	if _, ok := r.peers[p]; ok {
+		return
+	}
+	r.peers[p] = false
+	r.reseen++
+}
+
+func (r *round) counts() (newPeers, reseen int) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.newPeers, r.reseen
+}
@@ -0,0 +1,67 @@
+package crawl
+
+import (
+	"context"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+// resolveAddrs expands the DNS multiaddrs among pi's addresses into IP
+// multiaddrs, keeping the other addresses as they are. Addresses that fail
+// to resolve are kept unresolved. It returns nil if pi has no DNS
+// multiaddrs.
+func (c *Crawler) resolveAddrs(pi pstore.PeerInfo) []ma.Multiaddr {
+	var dns bool
+	for _, a := range pi.Addrs {
+		if madns.Matches(a) {
+			dns = true
+			break
+		}
+	}
+	if !dns {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, c.cfg.dialTimeout)
+	defer cancel()
+
+	var out []ma.Multiaddr
+	for _, a := range pi.Addrs {
+		if !madns.Matches(a) {
+			out = append(out, a)
+			continue
+		}
+
+		rs, err := c.cfg.resolver.Resolve(ctx, a)
+		if err != nil || len(rs) == 0 {
+			c.log.Debugf("Error resolving %s for %s: %v", a, pi.ID.Pretty(), err)
+			out = append(out, a)
+			continue
+		}
+		for _, r := range rs {
+			if r = ownAddr(pi, r); r != nil {
+				out = append(out, r)
+			}
+		}
+	}
+	return out
+}
+
+// ownAddr strips the /ipfs component that dnsaddr records carry, returning
+// nil if the address belongs to a different peer.
+func ownAddr(pi pstore.PeerInfo, a ma.Multiaddr) ma.Multiaddr {
+	id, err := a.ValueForProtocol(ma.P_IPFS)
+	if err != nil {
+		return a
+	}
+	if id != pi.ID.Pretty() {
+		return nil
+	}
+	suffix, err := ma.NewMultiaddr("/ipfs/" + id)
+	if err != nil {
+		return nil
+	}
+	return a.Decapsulate(suffix)
+}
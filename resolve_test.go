@@ -0,0 +1,60 @@
+package crawl
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	madns "github.com/multiformats/go-multiaddr-dns"
+)
+
+func TestResolveDNSAddr(t *testing.T) {
+	p, other := testPeer(1).Pretty(), testPeer(2).Pretty()
+	resolver := &madns.Resolver{Backend: &madns.MockBackend{
+		TXT: map[string][]string{
+			"_dnsaddr.bootstrap.example.com": {
+				"dnsaddr=/ip4/8.1.1.1/tcp/4001/ipfs/" + p,
+				"dnsaddr=/ip4/8.1.1.2/tcp/4001/ipfs/" + p,
+				"dnsaddr=/ip4/8.2.2.2/tcp/4001/ipfs/" + other,
+			},
+		},
+	}}
+	d := newMockDHT(starGraph(1), 0)
+	d.setAddrs(1, "/dnsaddr/bootstrap.example.com")
+
+	var mx sync.Mutex
+	var dialed []string
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		if pi.ID == testPeer(1) {
+			mx.Lock()
+			defer mx.Unlock()
+			dialed = addrStrings(pi.Addrs)
+		}
+		return nil
+	}
+	c := newTestCrawler(t, h, d, WithResolver(resolver))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	want := "[/ip4/8.1.1.1/tcp/4001 /ip4/8.1.1.2/tcp/4001]"
+	if fmt.Sprint(dialed) != want {
+		t.Errorf("expected %s to be dialed, got %v", want, dialed)
+	}
+	for _, rec := range recs {
+		if rec.PeerInfo.ID != testPeer(1) {
+			continue
+		}
+		if got := fmt.Sprint(addrStrings(rec.ResolvedAddrs)); got != want {
+			t.Errorf("expected resolved addresses %s, got %s", want, got)
+		}
+		if got := fmt.Sprint(addrStrings(rec.PeerInfo.Addrs)); got != "[/dnsaddr/bootstrap.example.com]" {
+			t.Errorf("expected the record to keep the DNS address, got %s", got)
+		}
+		return
+	}
+	t.Error("the DNS-only peer wasn't recorded")
+}
@@ -0,0 +1,48 @@
+package crawl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// scaleInterval is how often the scaler adjusts the number of workers.
+var scaleInterval = time.Second
+
+func (c *Crawler) startWorker() {
+	atomic.AddInt64(&c.workers, 1)
+	c.wg.Add(1)
+	go c.worker()
+}
+
+// scaler adjusts the number of workers between the WithAutoScale bounds,
+// adding a worker whenever peers are left waiting in the queue and retiring
+// an idle one whenever the queue is empty.
+func (c *Crawler) scaler() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.draining:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+
+		n := int(atomic.LoadInt64(&c.workers))
+		switch depth := c.work.len(); {
+		case depth > 0 && n < c.cfg.maxWorkers:
+			c.log.Debugf("Queue depth %d; adding worker %d", depth, n+1)
+			c.startWorker()
+		case depth == 0 && n > c.cfg.workers:
+			select {
+			case c.retire <- struct{}{}:
+				c.log.Debugf("Queue empty; retired a worker, %d left", n-1)
+			default:
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package crawl
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestAutoScale(t *testing.T) {
+	defer func(d time.Duration) { scaleInterval = d }(scaleInterval)
+	scaleInterval = 10 * time.Millisecond
+
+	release := make(chan struct{})
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		<-release
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(20), 0), WithAutoScale(1, 4))
+	defer c.Close()
+
+	workers := func() int64 { return atomic.LoadInt64(&c.workers) }
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.CrawlRound(context.Background())
+	}()
+
+	waitFor(t, "the workers to scale up", func() bool { return workers() == 4 })
+	close(release)
+	<-done
+	waitFor(t, "the workers to scale down", func() bool { return workers() == 1 })
+}
@@ -0,0 +1,55 @@
+package crawl
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// LoadSeedsFromFile reads seed peers for WithSeedPeers from a file of
+// multiaddrs ending in /ipfs/<peer ID>, one per line. Blank lines and lines
+// starting with # are ignored. Addresses of the same peer are merged.
+func LoadSeedsFromFile(path string) ([]pstore.PeerInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var seeds []pstore.PeerInfo
+	index := make(map[peer.ID]int)
+
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		a, err := ma.NewMultiaddr(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid multiaddr %q: %s", path, n, line, err)
+		}
+		pi, err := pstore.InfoFromP2pAddr(a)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid peer address %q: %s", path, n, line, err)
+		}
+
+		if i, ok := index[pi.ID]; ok {
+			seeds[i].Addrs = append(seeds[i].Addrs, pi.Addrs...)
+			continue
+		}
+		index[pi.ID] = len(seeds)
+		seeds = append(seeds, *pi)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	return seeds, nil
+}
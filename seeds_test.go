@@ -0,0 +1,45 @@
+package crawl
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadSeedsFromFile(t *testing.T) {
+	seeds, err := LoadSeedsFromFile(filepath.Join("testdata", "seeds.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		id    string
+		addrs string
+	}{
+		{"QmaCpDMGvV2BGHeYERUEnRQAwe3N8SzbUtfsmvsqQLuvuJ", "[/ip4/104.131.131.82/tcp/4001 /ip6/2604:a880:1:20::203:d001/tcp/4001]"},
+		{"QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN", "[/dnsaddr/bootstrap.libp2p.io]"},
+	}
+	if len(seeds) != len(want) {
+		t.Fatalf("expected %d seeds, got %v", len(want), seeds)
+	}
+	for i, w := range want {
+		if id := seeds[i].ID.Pretty(); id != w.id {
+			t.Errorf("expected seed %d to be %s, got %s", i, w.id, id)
+		}
+		if addrs := fmt.Sprint(seeds[i].Addrs); addrs != w.addrs {
+			t.Errorf("expected seed %d at %s, got %s", i, w.addrs, addrs)
+		}
+	}
+}
+
+func TestLoadSeedsFromFileErrors(t *testing.T) {
+	_, err := LoadSeedsFromFile(filepath.Join("testdata", "seeds_invalid.txt"))
+	if err == nil || !strings.HasPrefix(err.Error(), filepath.Join("testdata", "seeds_invalid.txt")+":3: invalid peer address") {
+		t.Errorf("expected an error pointing at line 3, got %v", err)
+	}
+
+	if _, err := LoadSeedsFromFile(filepath.Join("testdata", "missing.txt")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,49 @@
+package crawl
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// RunUntilSignal crawls until the crawl stops on its own or one of sigs,
+// SIGINT or SIGTERM by default, is received. On a signal, the workers dial
+// the peers already queued before the crawler is closed; a second signal
+// closes it at once. It returns nil if stopped by a signal, and the Crawl
+// error otherwise.
+func (c *Crawler) RunUntilSignal(sigs ...os.Signal) error {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, sigs...)
+	defer signal.Stop(sigc)
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.Crawl() }()
+
+	select {
+	case err := <-errc:
+		c.Close()
+		return err
+	case sig := <-sigc:
+		c.log.Infof("Received %s; draining the crawl", sig)
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		c.drain()
+	}()
+
+	select {
+	case <-drained:
+	case sig := <-sigc:
+		c.log.Infof("Received %s; stopping now", sig)
+	}
+
+	c.Close()
+	<-drained
+	<-errc
+	return nil
+}
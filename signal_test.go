@@ -0,0 +1,65 @@
+//go:build !windows
+// +build !windows
+
+package crawl
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunUntilSignal(t *testing.T) {
+	// keep SIGUSR1 from killing the test binary even if it arrives after
+	// RunUntilSignal stopped listening
+	guard := make(chan os.Signal, 1)
+	signal.Notify(guard, syscall.SIGUSR1)
+	defer signal.Stop(guard)
+
+	d := newMockDHT(starGraph(3), 0)
+	c := newTestCrawler(t, newMockHost(), d)
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.RunUntilSignal(syscall.SIGUSR1) }()
+
+	// the crawl starts once the signal handler is installed
+	waitFor(t, "the crawl to start", func() bool { return len(d.queried()) > 0 })
+	if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("expected a clean shutdown, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl not stopped by the signal")
+	}
+
+	// the crawler is closed, so Records is too
+	for range c.Records {
+	}
+}
+
+func TestRunUntilSignalCrawlError(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0), WithAnchorStrategy(sequence("a")))
+	defer c.Close()
+
+	errc := make(chan error, 1)
+	go func() { errc <- c.RunUntilSignal(syscall.SIGUSR1) }()
+
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("expected the crawl error")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("RunUntilSignal didn't return when the crawl stopped")
+	}
+	for range c.Records {
+	}
+}
@@ -0,0 +1,151 @@
+package crawl
+
+import (
+	"database/sql"
+	"time"
+)
+
+// sink is a destination connected peer records are written to in addition to
+// Records. put must not block the worker.
+type sink interface {
+	put(rec PeerRecord)
+	close() error
+}
+
+const (
+	sqliteBatchSize     = 100
+	sqliteFlushInterval = time.Second
+
+	// sqliteDriver is the database/sql driver a SQLite sink configured by
+	// path is opened with. The crawler does not register it.
+	sqliteDriver = "sqlite3"
+)
+
+// sqliteSink upserts records into the peers table of a SQLite database,
+// batching them into transactions. The database is closed with the sink only
+// if the sink opened it.
+type sqliteSink struct {
+	db    *sql.DB
+	owned bool
+	log   Logger
+	in    chan PeerRecord
+	done  chan struct{}
+}
+
+func openSQLiteSink(path string, log Logger) (*sqliteSink, error) {
+	db, err := sql.Open(sqliteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := newSQLiteSink(db, log)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	s.owned = true
+	return s, nil
+}
+
+func newSQLiteSink(db *sql.DB, log Logger) (*sqliteSink, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS peers (
+		id TEXT PRIMARY KEY,
+		first_seen TIMESTAMP NOT NULL,
+		last_seen TIMESTAMP NOT NULL,
+		agent_version TEXT,
+		addr_count INTEGER
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sqliteSink{
+		db:   db,
+		log:  log,
+		in:   make(chan PeerRecord, sqliteBatchSize),
+		done: make(chan struct{}),
+	}
+	go s.run()
+
+	return s, nil
+}
+
+func (s *sqliteSink) put(rec PeerRecord) {
+	select {
+	case s.in <- rec:
+	default:
+		s.log.Warnf("SQLite sink is falling behind; dropping record for %s", rec.PeerInfo.ID.Pretty())
+	}
+}
+
+func (s *sqliteSink) close() error {
+	close(s.in)
+	<-s.done
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *sqliteSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(sqliteFlushInterval)
+	defer ticker.Stop()
+
+	var batch []PeerRecord
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := s.write(batch); err != nil {
+			s.log.Errorf("Error writing %d records to SQLite: %s", len(batch), err.Error())
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case rec, ok := <-s.in:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, rec)
+			if len(batch) >= sqliteBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (s *sqliteSink) write(batch []PeerRecord) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO peers (id, first_seen, last_seen, agent_version, addr_count)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(id) DO UPDATE SET
+			last_seen = excluded.last_seen,
+			agent_version = excluded.agent_version,
+			addr_count = excluded.addr_count`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+
+	for _, rec := range batch {
+		_, err := stmt.Exec(rec.PeerInfo.ID.Pretty(), rec.DiscoveredAt, rec.DiscoveredAt,
+			rec.AgentVersion, len(rec.PeerInfo.Addrs))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,210 @@
+package crawl
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingDriver stands in for a SQLite driver, keeping the rows inserted
+// into each database by their first column, the peer ID. Inserts of existing
+// rows fail unless they set columns ON CONFLICT DO UPDATE, which it applies.
+// It is registered under the driver name the sink opens paths with.
+type recordingDriver struct {
+	mx  sync.Mutex
+	dbs map[string]*recordingDB
+}
+
+var testDriver = &recordingDriver{dbs: make(map[string]*recordingDB)}
+
+func init() {
+	sql.Register(sqliteDriver, testDriver)
+}
+
+// db returns the database opened by name.
+func (d *recordingDriver) db(name string) *recordingDB {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	db, ok := d.dbs[name]
+	if !ok {
+		db = &recordingDB{rows: make(map[string]map[string]driver.Value)}
+		d.dbs[name] = db
+	}
+	return db
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	return &recordingConn{db: d.db(name)}, nil
+}
+
+type recordingDB struct {
+	mx     sync.Mutex
+	rows   map[string]map[string]driver.Value
+	closed int
+}
+
+func (db *recordingDB) row(id string) (map[string]driver.Value, bool) {
+	db.mx.Lock()
+	defer db.mx.Unlock()
+	r, ok := db.rows[id]
+	return r, ok
+}
+
+type recordingConn struct {
+	db *recordingDB
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return &recordingStmt{db: c.db, query: query}, nil
+}
+
+func (c *recordingConn) Close() error {
+	c.db.mx.Lock()
+	defer c.db.mx.Unlock()
+	c.db.closed++
+	return nil
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) { return recordingTx{}, nil }
+
+type recordingTx struct{}
+
+func (recordingTx) Commit() error   { return nil }
+func (recordingTx) Rollback() error { return nil }
+
+type recordingStmt struct {
+	db    *recordingDB
+	query string
+}
+
+func (s *recordingStmt) Close() error  { return nil }
+func (s *recordingStmt) NumInput() int { return -1 }
+
+func (s *recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	q := strings.Join(strings.Fields(s.query), " ")
+	if !strings.HasPrefix(q, "INSERT") {
+		return driver.RowsAffected(0), nil
+	}
+
+	// INSERT INTO peers (col, ...) VALUES (?, ...) [ON CONFLICT(id) DO
+	// UPDATE SET col = excluded.col, ...]
+	cols := strings.Split(q[strings.Index(q, "(")+1:strings.Index(q, ")")], ", ")
+	if len(cols) != len(args) {
+		return nil, fmt.Errorf("%d columns but %d arguments", len(cols), len(args))
+	}
+	row := make(map[string]driver.Value, len(cols))
+	for i, col := range cols {
+		row[col] = args[i]
+	}
+
+	s.db.mx.Lock()
+	defer s.db.mx.Unlock()
+	id := args[0].(string)
+	old, ok := s.db.rows[id]
+	if !ok {
+		s.db.rows[id] = row
+		return driver.RowsAffected(1), nil
+	}
+	i := strings.Index(q, "DO UPDATE SET ")
+	if i < 0 {
+		return nil, fmt.Errorf("UNIQUE constraint failed: %s", id)
+	}
+	for _, set := range strings.Split(q[i+len("DO UPDATE SET "):], ", ") {
+		var col, from string
+		if _, err := fmt.Sscanf(set, "%s = excluded.%s", &col, &from); err != nil {
+			return nil, fmt.Errorf("unsupported update %q", set)
+		}
+		old[col] = row[from]
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("queries not supported")
+}
+
+func TestSQLiteSink(t *testing.T) {
+	db, err := sql.Open(sqliteDriver, "TestSQLiteSink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	h := newMockHost()
+	h.ps.Put(testPeer(1), "AgentVersion", "go-ipfs/0.4.18/")
+	c := newTestCrawler(t, h, newMockDHT(starGraph(2), 0), WithSQLiteSink(db))
+	crawlOnce(t, c)
+	c.Close()
+
+	rdb := testDriver.db("TestSQLiteSink")
+	for n := 0; n < 3; n++ {
+		row, ok := rdb.row(testPeer(n).Pretty())
+		if !ok {
+			t.Errorf("no row for peer %d", n)
+			continue
+		}
+		if n == 1 && row["agent_version"] != "go-ipfs/0.4.18/" {
+			t.Errorf("expected peer 1's agent version in its row, got %v", row)
+		}
+	}
+	if err := db.Ping(); err != nil {
+		t.Errorf("expected a database passed in to stay open, got %s", err)
+	}
+}
+
+func TestSQLiteSinkPath(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0), WithSQLitePath("TestSQLiteSinkPath"))
+	crawlOnce(t, c)
+	c.Close()
+
+	rdb := testDriver.db("TestSQLiteSinkPath")
+	if _, ok := rdb.row(testPeer(0).Pretty()); !ok {
+		t.Error("no row for peer 0")
+	}
+	rdb.mx.Lock()
+	defer rdb.mx.Unlock()
+	if rdb.closed == 0 {
+		t.Error("expected a database opened by path to be closed with the crawler")
+	}
+}
+
+func TestSQLiteSinkUpsert(t *testing.T) {
+	// a database of its own, empty however often the test runs
+	name := fmt.Sprintf("TestSQLiteSinkUpsert-%d", time.Now().UnixNano())
+	db, err := sql.Open(sqliteDriver, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// crawl peer 0 twice into the same database
+	var seen []time.Time
+	for i := 0; i < 2; i++ {
+		c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0), WithSQLiteSink(db))
+		recs := crawlOnce(t, c)
+		c.Close()
+		if len(recs) != 1 {
+			t.Fatalf("expected 1 record, got %d", len(recs))
+		}
+		seen = append(seen, recs[0].DiscoveredAt)
+	}
+
+	if !seen[1].After(seen[0]) {
+		t.Fatalf("expected the second crawl to see peer 0 later, got %s then %s", seen[0], seen[1])
+	}
+	row, ok := testDriver.db(name).row(testPeer(0).Pretty())
+	if !ok {
+		t.Fatal("no row for peer 0")
+	}
+	if first, _ := row["first_seen"].(time.Time); !first.Equal(seen[0]) {
+		t.Errorf("expected first_seen to be kept at %s, got %v", seen[0], row["first_seen"])
+	}
+	if last, _ := row["last_seen"].(time.Time); !last.Equal(seen[1]) {
+		t.Errorf("expected last_seen to be updated to %s, got %v", seen[1], row["last_seen"])
+	}
+}
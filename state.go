@@ -0,0 +1,125 @@
+package crawl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-peer"
+)
+
+const stateSaveInterval = time.Minute
+
+// state is the on-disk representation of the crawl state.
+type state struct {
+	Peers []string `json:"peers"`
+}
+
+// loadState pre-seeds the visited set from the state file. A missing or
+// unreadable state file starts the crawl afresh.
+func (c *Crawler) loadState() {
+	ps, err := c.readState(c.cfg.stateFile)
+	switch {
+	case os.IsNotExist(err):
+		return
+	case err != nil:
+		c.log.Warnf("Error reading state file %s: %s; starting afresh", c.cfg.stateFile, err.Error())
+		return
+	}
+
+	for _, p := range ps {
+		c.peers.markSeen(p, "")
+	}
+
+	c.log.Infof("Loaded %d peers from state file %s", c.peers.len(), c.cfg.stateFile)
+}
+
+// readState returns the peers in the state file at path, skipping bad peer
+// IDs.
+func (c *Crawler) readState(path string) ([]peer.ID, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var st state
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("corrupt state file: %s", err)
+	}
+
+	ps := make([]peer.ID, 0, len(st.Peers))
+	for _, s := range st.Peers {
+		p, err := peer.IDB58Decode(s)
+		if err != nil {
+			c.log.Warnf("Ignoring bad peer ID %q in state file %s: %s", s, path, err.Error())
+			continue
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+// saveState atomically replaces the state file with the current visited set.
+func (c *Crawler) saveState() error {
+	ps := c.peers.list()
+	st := state{Peers: make([]string, 0, len(ps))}
+	for _, p := range ps {
+		st.Peers = append(st.Peers, peer.IDB58Encode(p))
+	}
+
+	data, err := json.Marshal(&st)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(c.cfg.stateFile), filepath.Base(c.cfg.stateFile)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), c.cfg.stateFile)
+}
+
+// stateSaver periodically snapshots the visited set to the state file,
+// saving one last time when the crawl stops.
+func (c *Crawler) stateSaver() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(stateSaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.draining:
+		case <-c.ctx.Done():
+		}
+
+		if err := c.saveState(); err != nil {
+			c.log.Errorf("Error saving state file %s: %s", c.cfg.stateFile, err.Error())
+		}
+
+		select {
+		case <-c.draining:
+			return
+		case <-c.ctx.Done():
+			return
+		default:
+		}
+	}
+}
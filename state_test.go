@@ -0,0 +1,38 @@
+package crawl
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStateFileResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ipfs-crawl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.json")
+
+	c := newTestCrawler(t, newMockHost(), newMockDHT(map[int][]int{0: {1}}, 0), WithStateFile(path))
+	if recs := crawlOnce(t, c); len(recs) != 2 {
+		t.Fatalf("expected 2 records from the first crawl, got %d", len(recs))
+	}
+	c.Close()
+
+	d := newMockDHT(map[int][]int{0: {1}}, 0, 2)
+	c = newTestCrawler(t, newMockHost(), d, WithStateFile(path))
+	defer c.Close()
+
+	recs := crawlOnce(t, c)
+
+	if len(recs) != 1 || recs[0].PeerInfo.ID != testPeer(2) {
+		t.Fatalf("expected only peer 2 to be recorded, got %v", recs)
+	}
+	for _, n := range []int{0, 1} {
+		if l := d.lookups(testPeer(n)); l != 0 {
+			t.Errorf("previously seen peer %d looked up %d times", n, l)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package crawl
+
+import "time"
+
+// statsReporter logs a summary of the crawl's progress every
+// WithStatsInterval until the crawl stops.
+func (c *Crawler) statsReporter() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.cfg.statsInterval)
+	defer ticker.Stop()
+
+	last, lastAt := c.Stats(), time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.draining:
+			return
+		case <-c.ctx.Done():
+			return
+		}
+
+		st, now := c.Stats(), time.Now()
+		rate := float64(st.Peers-last.Peers) / now.Sub(lastAt).Seconds()
+		c.log.Infof("Crawl stats: %d peers (%.1f/s), %d connects, %d failures, %d queued",
+			st.Peers, rate, st.Connects, st.Failures, st.QueueDepth)
+		last, lastAt = st, now
+	}
+}
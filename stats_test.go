@@ -0,0 +1,53 @@
+package crawl
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// statsLines returns the number of stats lines logged to l.
+func statsLines(l *testLogger) int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	n := 0
+	for _, m := range l.msgs {
+		if strings.HasPrefix(m, "INFO Crawl stats: ") {
+			n++
+		}
+	}
+	return n
+}
+
+func TestStatsInterval(t *testing.T) {
+	log := &testLogger{}
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0),
+		WithStatsInterval(5*time.Millisecond), WithLogger(log))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the crawl stats", func() bool {
+		return log.logged("INFO Crawl stats: 4 peers") && log.logged("4 connects, 0 failures, 0 queued")
+	})
+
+	c.Close()
+	n := statsLines(log)
+	time.Sleep(20 * time.Millisecond)
+	if m := statsLines(log); m != n {
+		t.Errorf("expected the reporter to stop on Close, got %d more stats lines", m-n)
+	}
+}
+
+func TestStatsIntervalUnset(t *testing.T) {
+	log := &testLogger{}
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(3), 0), WithLogger(log))
+
+	crawlOnce(t, c)
+
+	if n := statsLines(log); n != 0 {
+		t.Errorf("expected no stats lines by default, got %d", n)
+	}
+}
@@ -0,0 +1,134 @@
+package crawl
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	websocket "github.com/gorilla/websocket"
+)
+
+const (
+	streamBuffer       = 64
+	streamWriteTimeout = 10 * time.Second
+)
+
+// broadcaster is a sink fanning records out to subscribers, such as the
+// clients of the /stream endpoint. Records are dropped for subscribers that
+// fall behind.
+type broadcaster struct {
+	mx     sync.Mutex
+	subs   map[chan PeerRecord]struct{}
+	closed bool
+}
+
+func newBroadcaster() *broadcaster {
+	return &broadcaster{subs: make(map[chan PeerRecord]struct{})}
+}
+
+func (b *broadcaster) put(rec PeerRecord) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- rec:
+		default:
+		}
+	}
+}
+
+func (b *broadcaster) close() error {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	b.closed = true
+	for ch := range b.subs {
+		close(ch)
+		delete(b.subs, ch)
+	}
+	return nil
+}
+
+// subscribe returns a channel receiving every record put from now on. It is
+// closed when the broadcaster is, or by unsubscribe.
+func (b *broadcaster) subscribe() chan PeerRecord {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	ch := make(chan PeerRecord, streamBuffer)
+	if b.closed {
+		close(ch)
+		return ch
+	}
+	b.subs[ch] = struct{}{}
+	return ch
+}
+
+func (b *broadcaster) unsubscribe(ch chan PeerRecord) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+	if _, ok := b.subs[ch]; ok {
+		close(ch)
+		delete(b.subs, ch)
+	}
+}
+
+// serveStream upgrades the request to a WebSocket and writes each connected
+// peer's record to it as JSON until the client goes away or the crawler is
+// closed.
+func (c *Crawler) serveStream(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{}
+	if c.cfg.streamOrigins != nil {
+		upgrader.CheckOrigin = c.checkOrigin
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		c.log.Debugf("Error upgrading stream request: %s", err.Error())
+		return
+	}
+	defer conn.Close()
+
+	ch := c.stream.subscribe()
+	defer c.stream.unsubscribe(ch)
+
+	// the client doesn't send anything; reading only detects it leaving
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case rec, ok := <-ch:
+			if !ok {
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if err := conn.WriteJSON(rec); err != nil {
+				c.log.Debugf("Error writing to stream: %s", err.Error())
+				return
+			}
+		case <-gone:
+			return
+		}
+	}
+}
+
+// checkOrigin accepts requests from the Handler's own host, as the upgrader
+// does by default, and from the origins allowed by WithStreamOrigins.
+func (c *Crawler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if _, ok := c.cfg.streamOrigins[strings.ToLower(origin)]; ok {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
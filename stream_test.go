@@ -0,0 +1,107 @@
+package crawl
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	websocket "github.com/gorilla/websocket"
+)
+
+// dialStream connects to the /stream endpoint of srv with the given Origin
+// header, if any.
+func dialStream(srv *httptest.Server, origin string) (*websocket.Conn, error) {
+	hdr := make(http.Header)
+	if origin != "" {
+		hdr.Set("Origin", origin)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/stream", hdr)
+	return conn, err
+}
+
+func TestStream(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(2), 0))
+	defer c.Close()
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	conn, err := dialStream(srv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	waitFor(t, "the stream subscription", func() bool {
+		c.stream.mx.Lock()
+		defer c.stream.mx.Unlock()
+		return len(c.stream.subs) == 1
+	})
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		var rec jsonRecord
+		if err := conn.ReadJSON(&rec); err != nil {
+			t.Fatal(err)
+		}
+		ids[rec.ID] = true
+	}
+	for n := 0; n < 3; n++ {
+		if !ids[testPeer(n).Pretty()] {
+			t.Errorf("peer %d not streamed", n)
+		}
+	}
+
+	// the stream ends when the crawler is closed
+	c.Close()
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Error("expected the stream to be closed")
+	}
+}
+
+func TestStreamOrigins(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{nil, "", true},
+		{nil, "https://evil.example", false},
+		{[]string{"https://dashboard.example"}, "https://dashboard.example", true},
+		{[]string{"https://dashboard.example"}, "https://DASHBOARD.example", true},
+		{[]string{"https://dashboard.example"}, "https://evil.example", false},
+	} {
+		var opts []Option
+		if tc.allowed != nil {
+			opts = append(opts, WithStreamOrigins(tc.allowed...))
+		}
+		c := newTestCrawler(t, newMockHost(), newMockDHT(nil), opts...)
+		srv := httptest.NewServer(c.Handler())
+
+		conn, err := dialStream(srv, tc.origin)
+		if err == nil {
+			conn.Close()
+		}
+		if ok := err == nil; ok != tc.ok {
+			t.Errorf("origin %q allowing %v: expected the upgrade to succeed to be %t, got %v", tc.origin, tc.allowed, tc.ok, err)
+		}
+
+		srv.Close()
+		c.Close()
+	}
+
+	// the same origin as the endpoint is always allowed
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil), WithStreamOrigins("https://dashboard.example"))
+	defer c.Close()
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+	conn, err := dialStream(srv, srv.URL)
+	if err != nil {
+		t.Fatalf("expected the endpoint's own origin to be allowed, got %s", err)
+	}
+	conn.Close()
+}
@@ -0,0 +1,38 @@
+package crawl
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FinalSummary sums up a crawl once it has ended. Rounds counts each round
+// once, however many anchors and DHTs it crawled, and the seed peers as one.
+type FinalSummary struct {
+	TotalPeers int
+	Connected  uint64
+	Failed     uint64
+	Rounds     uint64
+	Duration   time.Duration
+}
+
+// Summary returns the summary of the crawl. Once the crawl has ended, as
+// signalled by Records being closed, the summary no longer changes; before
+// then it sums up the crawl so far.
+func (c *Crawler) Summary() FinalSummary {
+	c.emitMx.RLock()
+	defer c.emitMx.RUnlock()
+	if c.closed {
+		return c.final
+	}
+	return c.summary()
+}
+
+func (c *Crawler) summary() FinalSummary {
+	return FinalSummary{
+		TotalPeers: c.peers.len(),
+		Connected:  atomic.LoadUint64(&c.connects),
+		Failed:     atomic.LoadUint64(&c.failures),
+		Rounds:     atomic.LoadUint64(&c.rounds),
+		Duration:   time.Since(c.started),
+	}
+}
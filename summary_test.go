@@ -0,0 +1,76 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+)
+
+func TestFinalSummary(t *testing.T) {
+	h := newMockHost()
+	h.dial = func(ctx context.Context, pi pstore.PeerInfo) error {
+		if pi.ID == testPeer(3) {
+			return errors.New("connection refused")
+		}
+		return nil
+	}
+	c := newTestCrawler(t, h, newMockDHT(starGraph(3), 0), WithAnchorStrategy(sequence("a", "b")))
+	defer c.Close()
+
+	// the crawl ends when the anchors run out
+	if err := c.Crawl(); err == nil {
+		t.Fatal("expected the crawl to stop once out of anchors")
+	}
+	c.drain()
+
+	var discovered, failed, rounds uint64
+	var finished []FinalSummary
+	for ev := range c.Events() {
+		switch ev := ev.(type) {
+		case PeerDiscovered:
+			discovered++
+		case PeerFailed:
+			failed++
+		case RoundComplete:
+			rounds++
+		case CrawlFinished:
+			finished = append(finished, ev.Summary)
+		}
+	}
+
+	sum := c.Summary()
+	if sum.TotalPeers != 4 || sum.Connected != discovered || sum.Failed != failed || sum.Rounds != rounds {
+		t.Errorf("expected a summary of 4 peers, %d connected, %d failed and %d rounds, got %+v", discovered, failed, rounds, sum)
+	}
+	if discovered != 3 || failed != 1 || rounds != 2 {
+		t.Errorf("expected 3 discovered, 1 failed and 2 rounds, got %d, %d and %d", discovered, failed, rounds)
+	}
+	if len(finished) != 1 || finished[0] != sum {
+		t.Errorf("expected the summary to be the last event, got %v", finished)
+	}
+	if sum.Duration <= 0 {
+		t.Errorf("expected the crawl to have taken some time, got %s", sum.Duration)
+	}
+
+	// the summary is fixed once the crawl has ended
+	time.Sleep(5 * time.Millisecond)
+	if again := c.Summary(); again != sum {
+		t.Errorf("expected the summary not to change after the crawl, got %+v then %+v", sum, again)
+	}
+}
+
+func TestSummaryDuringCrawl(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(starGraph(2), 0))
+	defer c.Close()
+
+	if err := c.CrawlRound(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the dials", func() bool { return c.Summary().Connected == 3 })
+	if sum := c.Summary(); sum.TotalPeers != 3 || sum.Rounds != 1 {
+		t.Errorf("expected the summary of the crawl so far, got %+v", sum)
+	}
+}
@@ -0,0 +1,55 @@
+package crawl
+
+import (
+	"context"
+	"time"
+)
+
+// Tracer is the interface the crawler traces the lookup and dialing of each
+// peer through. It is shaped after OpenTelemetry's tracer so that one can be
+// adapted to it without the crawler depending on OpenTelemetry.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span)
+}
+
+// Span is a step of a peer's crawl started by a Tracer.
+type Span interface {
+	AddEvent(name string, attrs ...Attr)
+	SetAttributes(attrs ...Attr)
+	// RecordError records that the step failed with err.
+	RecordError(err error)
+	End()
+}
+
+// Attr is a span attribute. Value is a string, an int or a float64.
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) AddEvent(name string, attrs ...Attr) {}
+func (nopSpan) SetAttributes(attrs ...Attr)         {}
+func (nopSpan) RecordError(err error)               {}
+func (nopSpan) End()                                {}
+
+// endSpan records the outcome of the step traced by span, and err if it
+// failed, then ends it.
+func endSpan(span Span, outcome string, err error, attrs ...Attr) {
+	span.SetAttributes(append(attrs, Attr{"outcome", outcome})...)
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+func durationMs(d time.Duration) Attr {
+	return Attr{"duration_ms", d.Seconds() * 1000}
+}
@@ -0,0 +1,113 @@
+package crawl
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// memTracer records the spans started through it.
+type memTracer struct {
+	mx    sync.Mutex
+	spans []*memSpan
+}
+
+func (t *memTracer) Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span) {
+	s := &memSpan{name: name, attrs: make(map[string]interface{})}
+	s.SetAttributes(attrs...)
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	t.spans = append(t.spans, s)
+	return ctx, s
+}
+
+// span returns the first span named name.
+func (t *memTracer) span(name string) *memSpan {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+	for _, s := range t.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+type memSpan struct {
+	name string
+
+	mx     sync.Mutex
+	attrs  map[string]interface{}
+	events []string
+	errs   []error
+	ended  bool
+}
+
+func (s *memSpan) AddEvent(name string, attrs ...Attr) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.events = append(s.events, name)
+}
+
+func (s *memSpan) SetAttributes(attrs ...Attr) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *memSpan) RecordError(err error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.errs = append(s.errs, err)
+}
+
+func (s *memSpan) End() {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	s.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	tr := &memTracer{}
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0),
+		WithTracer(tr), WithAnchorStrategy(sequence("anchor")))
+	defer c.Close()
+
+	crawlOnce(t, c)
+
+	id := testPeer(0).Pretty()
+	for _, want := range []struct {
+		name  string
+		attrs map[string]interface{}
+		event string
+	}{
+		{"crawlPeer", map[string]interface{}{"peer.id": id, "anchor": "anchor", "outcome": "queued"}, "FindPeer"},
+		{"tryConnect", map[string]interface{}{"peer.id": id, "addrs": 1, "outcome": "connected", "retries": 0}, ""},
+	} {
+		s := tr.span(want.name)
+		if s == nil {
+			t.Errorf("no %s span", want.name)
+			continue
+		}
+		if !s.ended {
+			t.Errorf("%s span not ended", want.name)
+		}
+		if len(s.errs) != 0 {
+			t.Errorf("expected no errors on the %s span, got %v", want.name, s.errs)
+		}
+		for k, v := range want.attrs {
+			if s.attrs[k] != v {
+				t.Errorf("expected %s on the %s span to be %v, got %v", k, want.name, v, s.attrs[k])
+			}
+		}
+		if _, ok := s.attrs["duration_ms"]; !ok && want.name == "tryConnect" {
+			t.Error("expected the dial duration on the tryConnect span")
+		}
+		if want.event != "" && (len(s.events) != 1 || s.events[0] != want.event) {
+			t.Errorf("expected a %s event on the %s span, got %v", want.event, want.name, s.events)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrNoPeers is returned by Validate when the host isn't connected to any
+// peers, which leaves the DHT with no one to query.
+var ErrNoPeers = errors.New("host is not connected to any peers; is the DHT bootstrapped?")
+
+// bootstrapper is implemented by DHTs that can be bootstrapped, such as
+// *dht.IpfsDHT.
+type bootstrapper interface {
+	Bootstrap(ctx context.Context) error
+}
+
+// Validate checks that the crawl can make progress: that the host has peers
+// to query the DHT through. With WithBootstrap, it first bootstraps the DHTs
+// and then waits until ctx is done for the host to connect to peers.
+func (c *Crawler) Validate(ctx context.Context) error {
+	if !c.cfg.bootstrap {
+		if len(c.h.Network().Peers()) == 0 {
+			return ErrNoPeers
+		}
+		return nil
+	}
+
+	for _, d := range c.dhts {
+		b, ok := d.dht.(bootstrapper)
+		if !ok {
+			return fmt.Errorf("the %s DHT cannot be bootstrapped", d.name)
+		}
+		if err := b.Bootstrap(ctx); err != nil {
+			return fmt.Errorf("error bootstrapping the %s DHT: %s", d.name, err)
+		}
+	}
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for len(c.h.Network().Peers()) == 0 {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ErrNoPeers
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package crawl
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	pstore "github.com/libp2p/go-libp2p-peerstore"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// bootstrapDHT is a mock DHT that can be bootstrapped.
+type bootstrapDHT struct {
+	*mockDHT
+	bootstrap func(ctx context.Context) error
+}
+
+func (d *bootstrapDHT) Bootstrap(ctx context.Context) error {
+	return d.bootstrap(ctx)
+}
+
+func TestValidate(t *testing.T) {
+	h := newMockHost()
+	c := newTestCrawler(t, h, newMockDHT(nil, 0))
+	defer c.Close()
+
+	if err := c.Validate(context.Background()); err != ErrNoPeers {
+		t.Errorf("expected a host without peers not to validate, got %v", err)
+	}
+
+	h.net.connect(pstore.PeerInfo{ID: testPeer(0), Addrs: []ma.Multiaddr{testAddr(0)}})
+	if err := c.Validate(context.Background()); err != nil {
+		t.Errorf("expected a connected host to validate, got %v", err)
+	}
+}
+
+func TestValidateBootstrap(t *testing.T) {
+	c := newTestCrawler(t, newMockHost(), newMockDHT(nil, 0), WithBootstrap(true))
+	defer c.Close()
+	if err := c.Validate(context.Background()); err == nil || !strings.Contains(err.Error(), "cannot be bootstrapped") {
+		t.Errorf("expected a DHT without Bootstrap to fail validation, got %v", err)
+	}
+
+	h := newMockHost()
+	d := &bootstrapDHT{mockDHT: newMockDHT(nil, 0)}
+	d.bootstrap = func(ctx context.Context) error {
+		return errors.New("no bootstrap peers")
+	}
+	c = newTestCrawler(t, h, d, WithBootstrap(true))
+	defer c.Close()
+	if err := c.Validate(context.Background()); err == nil || !strings.Contains(err.Error(), "no bootstrap peers") {
+		t.Errorf("expected the bootstrap error, got %v", err)
+	}
+
+	// a bootstrap that never connects to anyone
+	d.bootstrap = func(ctx context.Context) error { return nil }
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.Validate(ctx); err != ErrNoPeers {
+		t.Errorf("expected a DHT that isn't ready to fail validation, got %v", err)
+	}
+
+	// a bootstrap that connects to a peer in the background
+	d.bootstrap = func(ctx context.Context) error {
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			h.net.connect(pstore.PeerInfo{ID: testPeer(0), Addrs: []ma.Multiaddr{testAddr(0)}})
+		}()
+		return nil
+	}
+	if err := c.Validate(context.Background()); err != nil {
+		t.Errorf("expected the bootstrapped host to validate, got %v", err)
+	}
+}